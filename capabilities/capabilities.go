@@ -0,0 +1,166 @@
+// Package capabilities contains the Linux capability names and the methods used to find out who holds them.
+package capabilities
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	DACOverride   = "cap_dac_override"
+	DACReadSearch = "cap_dac_read_search"
+)
+
+// names is indexed by capability number, as in linux/capability.h.
+var names = []string{
+	"cap_chown",
+	"cap_dac_override",
+	"cap_dac_read_search",
+	"cap_fowner",
+	"cap_fsetid",
+	"cap_kill",
+	"cap_setgid",
+	"cap_setuid",
+	"cap_setpcap",
+	"cap_linux_immutable",
+	"cap_net_bind_service",
+	"cap_net_broadcast",
+	"cap_net_admin",
+	"cap_net_raw",
+	"cap_ipc_lock",
+	"cap_ipc_owner",
+	"cap_sys_module",
+	"cap_sys_rawio",
+	"cap_sys_chroot",
+	"cap_sys_ptrace",
+	"cap_sys_pacct",
+	"cap_sys_admin",
+	"cap_sys_boot",
+	"cap_sys_nice",
+	"cap_sys_resource",
+	"cap_sys_time",
+	"cap_sys_tty_config",
+	"cap_mknod",
+	"cap_lease",
+	"cap_audit_write",
+	"cap_audit_control",
+	"cap_setfcap",
+	"cap_mac_override",
+	"cap_mac_admin",
+	"cap_syslog",
+	"cap_wake_alarm",
+	"cap_block_suspend",
+	"cap_audit_read",
+	"cap_perfmon",
+	"cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+// Names returns the names of the capabilities in a capability bit set. Bits the table does not know
+// about are named by number.
+func Names(set uint64) []string {
+	var result []string
+	for bit := 0; bit < 64; bit++ {
+		if set&(1<<bit) == 0 {
+			continue
+		}
+		if bit < len(names) {
+			result = append(result, names[bit])
+		} else {
+			result = append(result, "cap_"+strconv.Itoa(bit))
+		}
+	}
+	return result
+}
+
+// Contains reports whether the capability list holds the named capability.
+func Contains(caps []string, name string) bool {
+	for _, c := range caps {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessCapabilities reads the effective capabilities of every running process from /proc and returns
+// them by the filesystem UID of the process, which is the UID the kernel checks file access with.
+func ProcessCapabilities() (map[uint32][]string, error) {
+	statusFiles, err := filepath.Glob("/proc/[0-9]*/status")
+	if err != nil {
+		return nil, err
+	}
+
+	sets := map[uint32]uint64{}
+	for _, statusFile := range statusFiles {
+		uid, set, ok := readStatus(statusFile)
+		if ok && set != 0 {
+			sets[uid] |= set
+		}
+	}
+
+	holders := map[uint32][]string{}
+	for uid, set := range sets {
+		holders[uid] = Names(set)
+	}
+	return holders, nil
+}
+
+// readStatus returns the filesystem UID and effective capability set of a process. Processes can exit
+// while /proc is read, those are skipped.
+func readStatus(statusFile string) (uint32, uint64, bool) {
+	f, err := os.Open(statusFile)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+
+	var uid uint64
+	var set uint64
+	haveUID, haveSet := false, false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "Uid:":
+			// Real, effective, saved set and filesystem UID.
+			if len(fields) >= 5 {
+				uid, err = strconv.ParseUint(fields[4], 10, 32)
+				haveUID = err == nil
+			}
+		case "CapEff:":
+			if len(fields) >= 2 {
+				set, err = strconv.ParseUint(fields[1], 16, 64)
+				haveSet = err == nil
+			}
+		}
+	}
+	return uint32(uid), set, haveUID && haveSet
+}
+
+// escalating are the capabilities that are known to lead to root when held by a program that can be
+// made to do the attacker's bidding.
+var escalating = map[string]bool{
+	"cap_chown":           true,
+	"cap_dac_override":    true,
+	"cap_dac_read_search": true,
+	"cap_fowner":          true,
+	"cap_setgid":          true,
+	"cap_setuid":          true,
+	"cap_setfcap":         true,
+	"cap_sys_admin":       true,
+	"cap_sys_module":      true,
+	"cap_sys_ptrace":      true,
+	"cap_sys_rawio":       true,
+}
+
+// Escalating reports whether holding the named capability is known to lead to root.
+func Escalating(name string) bool {
+	return escalating[name]
+}
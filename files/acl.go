@@ -0,0 +1,73 @@
+package files
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	model "github.com/mykeelium/lshound/model"
+)
+
+const (
+	aclAccessXattr  = "system.posix_acl_access"
+	aclDefaultXattr = "system.posix_acl_default"
+
+	// The xattr is a little endian header holding the version, followed by
+	// 8 byte entries of tag (uint16), perm (uint16) and id (uint32).
+	aclXattrVersion    = 2
+	aclXattrHeaderSize = 4
+	aclXattrEntrySize  = 8
+)
+
+var aclTags = map[uint16]string{
+	0x01: model.ACLTagUserObj,
+	0x02: model.ACLTagUser,
+	0x04: model.ACLTagGroupObj,
+	0x08: model.ACLTagGroup,
+	0x10: model.ACLTagMask,
+	0x20: model.ACLTagOther,
+}
+
+func parseACL(data []byte) ([]model.ACLEntry, error) {
+	if len(data) < aclXattrHeaderSize || (len(data)-aclXattrHeaderSize)%aclXattrEntrySize != 0 {
+		return nil, fmt.Errorf("malformed acl xattr of %d bytes", len(data))
+	}
+	if version := binary.LittleEndian.Uint32(data); version != aclXattrVersion {
+		return nil, fmt.Errorf("unsupported acl xattr version %d", version)
+	}
+
+	var entries []model.ACLEntry
+	for off := aclXattrHeaderSize; off < len(data); off += aclXattrEntrySize {
+		tag, ok := aclTags[binary.LittleEndian.Uint16(data[off:])]
+		if !ok {
+			return nil, fmt.Errorf("unknown acl tag %#x", binary.LittleEndian.Uint16(data[off:]))
+		}
+		entry := model.ACLEntry{
+			Tag:  tag,
+			Perm: uint8(binary.LittleEndian.Uint16(data[off+2:]) & 0o7),
+		}
+		if tag == model.ACLTagUser || tag == model.ACLTagGroup {
+			entry.ID = binary.LittleEndian.Uint32(data[off+4:])
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func readACL(path string, name string) ([]model.ACLEntry, error) {
+	data, err := getxattr(path, name, false)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return parseACL(data)
+}
+
+// hasExtendedEntries reports whether the ACL grants anything beyond what the mode bits already express.
+func hasExtendedEntries(entries []model.ACLEntry) bool {
+	for _, entry := range entries {
+		switch entry.Tag {
+		case model.ACLTagUser, model.ACLTagGroup, model.ACLTagMask:
+			return true
+		}
+	}
+	return false
+}
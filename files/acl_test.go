@@ -0,0 +1,99 @@
+package files
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	model "github.com/mykeelium/lshound/model"
+)
+
+// aclXattr encodes entries of tag, perm and id the way the kernel stores them.
+func aclXattr(version uint32, entries ...[3]uint32) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, version)
+	for _, entry := range entries {
+		data = binary.LittleEndian.AppendUint16(data, uint16(entry[0]))
+		data = binary.LittleEndian.AppendUint16(data, uint16(entry[1]))
+		data = binary.LittleEndian.AppendUint32(data, entry[2])
+	}
+	return data
+}
+
+func TestParseACL(t *testing.T) {
+	// IDs of the entries without one are stored as -1.
+	const undefinedID = 0xffffffff
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []model.ACLEntry
+		wantErr bool
+	}{
+		{
+			name: "named entries and mask",
+			data: aclXattr(2,
+				[3]uint32{0x01, 0o7, undefinedID},
+				[3]uint32{0x02, 0o6, 1000},
+				[3]uint32{0x04, 0o5, undefinedID},
+				[3]uint32{0x08, 0o4, 100},
+				[3]uint32{0x10, 0o5, undefinedID},
+				[3]uint32{0x20, 0o1, undefinedID},
+			),
+			want: []model.ACLEntry{
+				{Tag: model.ACLTagUserObj, Perm: 0o7},
+				{Tag: model.ACLTagUser, ID: 1000, Perm: 0o6},
+				{Tag: model.ACLTagGroupObj, Perm: 0o5},
+				{Tag: model.ACLTagGroup, ID: 100, Perm: 0o4},
+				{Tag: model.ACLTagMask, Perm: 0o5},
+				{Tag: model.ACLTagOther, Perm: 0o1},
+			},
+		},
+		{
+			name: "bits above rwx are dropped",
+			data: aclXattr(2, [3]uint32{0x01, 0o17, undefinedID}),
+			want: []model.ACLEntry{{Tag: model.ACLTagUserObj, Perm: 0o7}},
+		},
+		{
+			name: "header only",
+			data: aclXattr(2),
+		},
+		{
+			name:    "unsupported version",
+			data:    aclXattr(1, [3]uint32{0x01, 0o7, undefinedID}),
+			wantErr: true,
+		},
+		{
+			name:    "unknown tag",
+			data:    aclXattr(2, [3]uint32{0x40, 0o7, undefinedID}),
+			wantErr: true,
+		},
+		{
+			name:    "truncated entry",
+			data:    aclXattr(2, [3]uint32{0x01, 0o7, undefinedID})[:10],
+			wantErr: true,
+		},
+		{
+			name:    "truncated header",
+			data:    []byte{2, 0},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseACL(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseACL() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseACL() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package files
+
+// Inode flags as returned by the FS_IOC_GETFLAGS ioctl, see linux/fs.h and chattr(1).
+const (
+	fsImmutableFlag = 0x00000010
+	fsAppendFlag    = 0x00000020
+)
+
+var attributeNames = []struct {
+	flag uint32
+	name string
+}{
+	{0x00000001, "secure_deletion"},
+	{0x00000002, "undeletable"},
+	{0x00000004, "compressed"},
+	{0x00000008, "sync"},
+	{fsImmutableFlag, "immutable"},
+	{fsAppendFlag, "append_only"},
+	{0x00000040, "nodump"},
+	{0x00000080, "noatime"},
+	{0x00000800, "encrypted"},
+	{0x00001000, "indexed"},
+	{0x00004000, "journal_data"},
+	{0x00008000, "notail"},
+	{0x00010000, "dirsync"},
+	{0x00020000, "topdir"},
+	{0x00080000, "extents"},
+	{0x00100000, "verity"},
+	{0x00800000, "nocow"},
+	{0x02000000, "dax"},
+	{0x20000000, "project_inherit"},
+	{0x40000000, "casefold"},
+}
+
+func attributeList(flags uint32) []string {
+	var attributes []string
+	for _, attribute := range attributeNames {
+		if flags&attribute.flag != 0 {
+			attributes = append(attributes, attribute.name)
+		}
+	}
+	return attributes
+}
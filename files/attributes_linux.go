@@ -0,0 +1,32 @@
+//go:build linux
+
+package files
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// fsIocGetflags is _IOR('f', 1, long), its size field follows the size of long on the platform.
+const fsIocGetflags = 0x80006601 | unsafe.Sizeof(uintptr(0))<<16
+
+// readAttributes returns the inode flags of a file or directory. Files that cannot be opened and
+// filesystems without inode flags report none.
+func readAttributes(path string) (uint32, error) {
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return 0, nil
+	}
+	defer syscall.Close(fd)
+
+	// The kernel stores an int regardless of the size in the ioctl number.
+	var flags uint32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), fsIocGetflags, uintptr(unsafe.Pointer(&flags)))
+	switch errno {
+	case 0:
+		return flags, nil
+	case syscall.ENOTTY, syscall.EINVAL, syscall.EOPNOTSUPP:
+		return 0, nil
+	}
+	return 0, errno
+}
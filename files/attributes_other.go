@@ -0,0 +1,8 @@
+//go:build !linux
+
+package files
+
+// readAttributes is only implemented on Linux, other systems report no inode flags.
+func readAttributes(path string) (uint32, error) {
+	return 0, nil
+}
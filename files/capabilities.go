@@ -0,0 +1,59 @@
+package files
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mykeelium/lshound/capabilities"
+	model "github.com/mykeelium/lshound/model"
+)
+
+const (
+	capabilityXattr = "security.capability"
+
+	// The xattr is a vfs_cap_data: a little endian magic holding the revision and flags, then a permitted
+	// and an inheritable word for each 32 capabilities, and since revision 3 the root UID of the user namespace.
+	capRevisionMask  = 0xFF000000
+	capRevision1     = 0x01000000
+	capRevision2     = 0x02000000
+	capRevision3     = 0x03000000
+	capFlagEffective = 0x000001
+)
+
+// parseCapabilities decodes a security.capability xattr onto the record.
+func parseCapabilities(data []byte, rec *model.FileInfoRecord) error {
+	if len(data) < 4 {
+		return fmt.Errorf("malformed capability xattr of %d bytes", len(data))
+	}
+	magic := binary.LittleEndian.Uint32(data)
+
+	words := 2
+	size := 4 + 8*words
+	switch magic & capRevisionMask {
+	case capRevision1:
+		words = 1
+		size = 4 + 8*words
+	case capRevision2:
+	case capRevision3:
+		size += 4
+	default:
+		return fmt.Errorf("unsupported capability xattr revision %#x", magic&capRevisionMask)
+	}
+	if len(data) < size {
+		return fmt.Errorf("malformed capability xattr of %d bytes", len(data))
+	}
+
+	var permitted, inheritable uint64
+	for i := 0; i < words; i++ {
+		permitted |= uint64(binary.LittleEndian.Uint32(data[4+8*i:])) << (32 * i)
+		inheritable |= uint64(binary.LittleEndian.Uint32(data[8+8*i:])) << (32 * i)
+	}
+
+	rec.Capabilities = capabilities.Names(permitted)
+	rec.CapInheritable = capabilities.Names(inheritable)
+	rec.CapEffective = magic&capFlagEffective != 0
+	if magic&capRevisionMask == capRevision3 {
+		rec.CapRootID = binary.LittleEndian.Uint32(data[4+8*words:])
+	}
+	return nil
+}
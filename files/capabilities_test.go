@@ -0,0 +1,94 @@
+package files
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	model "github.com/mykeelium/lshound/model"
+)
+
+// capabilityXattrData encodes a vfs_cap_data of magic followed by the given words.
+func capabilityXattrData(magic uint32, words ...uint32) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, magic)
+	for _, word := range words {
+		data = binary.LittleEndian.AppendUint32(data, word)
+	}
+	return data
+}
+
+func TestParseCapabilities(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    model.FileInfoRecord
+		wantErr bool
+	}{
+		{
+			name: "revision 2 with both words",
+			data: capabilityXattrData(capRevision2|capFlagEffective, 1<<13|1<<7, 1<<0, 1<<6, 0),
+			want: model.FileInfoRecord{
+				Capabilities:   []string{"cap_setuid", "cap_net_raw", "cap_perfmon"},
+				CapInheritable: []string{"cap_chown"},
+				CapEffective:   true,
+			},
+		},
+		{
+			name: "revision 3 holds the namespace root",
+			data: capabilityXattrData(capRevision3, 1<<10, 0, 0, 0, 100000),
+			want: model.FileInfoRecord{
+				Capabilities: []string{"cap_net_bind_service"},
+				CapRootID:    100000,
+			},
+		},
+		{
+			name: "revision 1 has a single word",
+			data: capabilityXattrData(capRevision1|capFlagEffective, 1<<1, 0),
+			want: model.FileInfoRecord{
+				Capabilities: []string{"cap_dac_override"},
+				CapEffective: true,
+			},
+		},
+		{
+			name: "capabilities without a name",
+			data: capabilityXattrData(capRevision2, 0, 0, 1<<31, 0),
+			want: model.FileInfoRecord{
+				Capabilities: []string{"cap_63"},
+			},
+		},
+		{
+			name:    "revision 3 without the namespace root",
+			data:    capabilityXattrData(capRevision3, 1<<10, 0, 0, 0),
+			wantErr: true,
+		},
+		{
+			name:    "unknown revision",
+			data:    capabilityXattrData(0x04000000, 0, 0, 0, 0),
+			wantErr: true,
+		},
+		{
+			name:    "too short",
+			data:    []byte{0, 0, 0},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rec model.FileInfoRecord
+			err := parseCapabilities(tt.data, &rec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseCapabilities() = %+v, want an error", rec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rec, tt.want) {
+				t.Errorf("parseCapabilities() = %+v, want %+v", rec, tt.want)
+			}
+		})
+	}
+}
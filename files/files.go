@@ -2,18 +2,15 @@
 package files
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
-	"os/user"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"syscall"
 
+	"github.com/mykeelium/lshound/identity"
 	model "github.com/mykeelium/lshound/model"
+	"github.com/mykeelium/lshound/mounts"
 )
 
 func modeToStirng(m os.FileMode) (string, bool, bool, bool) {
@@ -58,192 +55,218 @@ func modeToStirng(m os.FileMode) (string, bool, bool, bool) {
 	return string(b[:]), setUID, setGID, isVTX
 }
 
-func uidToUser(uid uint32) string {
-	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
-	if err == nil {
-		return u.Username
-	}
-
-	f, ferr := os.Open("/etc/passwd")
-	if ferr != nil {
-		return ""
-	}
-	defer f.Close()
-	sc := bufio.NewScanner(f)
-	uidStr := strconv.FormatUint(uint64(uid), 10)
-	for sc.Scan() {
-		line := sc.Text()
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		parts := strings.Split(line, ":")
-		if len(parts) >= 3 && parts[2] == uidStr {
-			return parts[0]
-		}
-	}
-	return ""
+// Options controls which paths are walked and what is collected about them.
+type Options struct {
+	// MaxDepth is the recursive depth relative to the start, -1 is unlimited.
+	MaxDepth      int
+	FollowSymlink bool
+	SkipACL       bool
+	// Mounts is used to find the mount each path lives on, it may be nil.
+	Mounts *mounts.Table
+	// Workers is the number of paths processed at the same time.
+	Workers int
+	// Identities resolves the owner and group names of each path.
+	Identities *identity.Index
+	// Filter leaves paths out of the walk.
+	Filter Filter
 }
 
-func gidToGroup(gid uint32) string {
-	g, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10))
-	if err == nil {
-		return g.Name
-	}
-	f, ferr := os.Open("/etc/group")
-	if ferr != nil {
-		return ""
-	}
-	defer f.Close()
-	sc := bufio.NewScanner(f)
-	gidStr := strconv.FormatUint(uint64(gid), 10)
-	for sc.Scan() {
-		line := sc.Text()
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		parts := strings.Split(line, ":")
-		if len(parts) >= 3 && parts[2] == gidStr {
-			return parts[0]
-		}
+func fileType(mode os.FileMode) string {
+	if mode&os.ModeSymlink != 0 {
+		return "syslink"
+	} else if mode.IsDir() {
+		return "dir"
+	} else if mode.IsRegular() {
+		return "file"
 	}
-	return ""
+	return "other"
 }
 
-func detectACL(path string) (bool, error) {
-	_, err := exec.LookPath("getfacl")
-	if err != nil {
-		return false, nil
-	}
+// SetMode fills in the fields of a record that follow from its mode.
+func SetMode(rec *model.FileInfoRecord, mode os.FileMode) {
+	rec.Mode = mode
+	rec.ModeString, rec.SetUID, rec.SetGID, rec.Sticky = modeToStirng(mode)
+	rec.ModeOctal = fmt.Sprintf("%#o", uint32(mode.Perm()))
+	rec.IsSymlink = (mode & os.ModeSymlink) != 0
+	rec.Type = fileType(mode)
+}
 
-	cmd := exec.Command("getfacl", "p", path)
-	out, err := cmd.Output()
-	if err != nil {
-		if ee, ok := err.(*exec.ExitError); ok {
-			out = append(out, ee.Stderr...)
-		} else {
-			return false, err
-		}
+// appendErr adds err, when there is one, to the errors of a record.
+func appendErr(rec *model.FileInfoRecord, err error) {
+	if err == nil {
+		return
 	}
-
-	scanner := bufio.NewScanner(bytes.NewReader(out))
-	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "#") || line == "" {
-			continue
-		}
-		if strings.HasPrefix(line, "user:") {
-			parts := strings.Split(line, ":")
-			if len(parts) >= 3 && parts[1] != "" {
-				if parts[1] != "" && parts[1] != "0" {
-					if parts[1] != "" {
-						return true, nil
-					}
-				}
-			}
-		}
-		if strings.HasPrefix(line, "group:") {
-			parts := strings.Split(line, ":")
-			if len(parts) >= 3 && parts[1] != "" {
-				return true, nil
-			}
-		}
-		if strings.HasPrefix(line, "mask:") || strings.HasPrefix(line, "default:") {
-			return true, nil
-		}
+	if rec.Err == "" {
+		rec.Err = err.Error()
+	} else {
+		rec.Err = rec.Err + "; " + err.Error()
 	}
-	return false, nil
 }
 
-func ProcessPath(path string, info os.FileInfo, skipACL bool) model.FileInfoRecord {
+func ProcessPath(path string, info os.FileInfo, opts Options) model.FileInfoRecord {
 	rec := model.FileInfoRecord{
 		Path:    path,
 		Size:    info.Size(),
 		ModTime: info.ModTime(),
 	}
 	mode := info.Mode()
-	rec.Mode = mode
-	rec.ModeString, rec.SetUID, rec.SetGID, _ = modeToStirng(mode)
-	rec.ModeOctal = fmt.Sprintf("%#o", uint32(mode.Perm()))
-	rec.IsSymlink = (mode & os.ModeSymlink) != 0
+	SetMode(&rec, mode)
 	if rec.IsSymlink {
-		rec.Type = "syslink"
 		if tgt, err := os.Readlink(path); err == nil {
 			rec.LinkTarget = tgt
+			rec.LinkChain = resolveLinkChain(path, tgt)
 		}
-	} else if mode.IsDir() {
-		rec.Type = "dir"
-	} else if mode.IsRegular() {
-		rec.Type = "file"
-	} else {
-		rec.Type = "other"
 	}
 
 	var stat syscall.Stat_t
 	if err := syscall.Lstat(path, &stat); err == nil {
 		rec.UID = uint32(stat.Uid)
 		rec.GID = uint32(stat.Gid)
-		rec.User = uidToUser(rec.UID)
-		rec.Group = gidToGroup(rec.GID)
+		rec.User = opts.Identities.UserName(rec.UID)
+		rec.Group = opts.Identities.GroupName(rec.GID)
 		rec.INode = uint64(stat.Ino)
+		rec.Dev = uint64(stat.Dev)
+		rec.NLink = uint64(stat.Nlink)
 	} else {
-		rec.Err = err.Error()
+		appendErr(&rec, err)
 	}
 
-	if !skipACL {
-		acl, err := detectACL(path)
-		if err != nil {
-			if rec.Err == "" {
-				rec.Err = err.Error()
-			} else {
-				rec.Err = rec.Err + "; " + err.Error()
-			}
+	// Only regular files and directories are opened for their inode flags, opening devices can have side effects.
+	if mode.IsRegular() || mode.IsDir() {
+		flags, err := readAttributes(path)
+		appendErr(&rec, err)
+		rec.Attributes = attributeList(flags)
+		rec.Immutable = flags&fsImmutableFlag != 0
+		rec.AppendOnly = flags&fsAppendFlag != 0
+	}
+
+	labels := []struct {
+		name  string
+		value *string
+	}{
+		{selinuxXattr, &rec.SELinuxLabel},
+		{smackXattr, &rec.SMACKLabel},
+	}
+	for _, label := range labels {
+		value, err := readLabel(path, label.name, rec.IsSymlink)
+		appendErr(&rec, err)
+		*label.value = value
+	}
+
+	if mode.IsRegular() {
+		data, err := getxattr(path, capabilityXattr, false)
+		if err == nil && data != nil {
+			err = parseCapabilities(data, &rec)
+		}
+		appendErr(&rec, err)
+	}
+
+	if mount := opts.Mounts.Lookup(path); mount != nil {
+		rec.MountID = mount.ID
+		rec.MountPoint = mount.MountPoint
+		rec.FSType = mount.FSType
+		rec.MountOptions = mount.Options
+	}
+
+	if !opts.SkipACL && !rec.IsSymlink {
+		entries, err := readACL(path, aclAccessXattr)
+		appendErr(&rec, err)
+		rec.ACLEntries = entries
+		rec.ACL = hasExtendedEntries(entries)
+
+		// Default ACLs only exist on directories and decide the ACL of everything created inside them.
+		if mode.IsDir() {
+			defaults, err := readACL(path, aclDefaultXattr)
+			appendErr(&rec, err)
+			rec.DefaultACL = defaults
+			rec.ACL = rec.ACL || len(defaults) > 0
 		}
-		rec.ACL = acl
 	}
 
 	return rec
 }
 
-func Walk(root string, maxDepth int, followSymlink bool, skipACL bool, out chan<- model.FileInfoRecord) error {
-	rootAbs, err := filepath.Abs(root)
-	if err == nil {
-		root = rootAbs
+// Walk collects every root and everything below it, then closes out. The roots share one walk, so a
+// directory reached from several of them is only collected once, and roots that lie below another root
+// are left to that root.
+func Walk(roots []string, opts Options, out chan<- model.FileInfoRecord) error {
+	pipeline := NewPipeline(opts, out)
+	w := newWalker(opts, pipeline)
+	for _, root := range distinctRoots(roots) {
+		ProcessAncestors(root, w.seen, pipeline)
+		info, err := os.Lstat(root)
+		if err != nil {
+			pipeline.Send(model.FileInfoRecord{Path: root, Err: err.Error()})
+			continue
+		}
+		// A root on a skipped filesystem was asked for explicitly, the filesystem is walked. Patterns and
+		// -xdev still apply below the root.
+		w.device = DeviceOf(info)
+		w.rootMount = opts.Mounts.Lookup(root)
+		w.visit(root, root, info, 0, opts.Filter.included(root))
 	}
-	rootDepth := len(strings.Split(filepath.Clean(root), string(os.PathSeparator)))
+	w.sendPlaceholders()
+	pipeline.Close()
+	close(out)
+	return nil
+}
 
-	err = filepath.WalkDir(root, func(path string, dEntry os.DirEntry, err error) error {
-		if err != nil {
-			rec := model.FileInfoRecord{Path: path, Err: err.Error()}
-			out <- rec
-			return nil
+// distinctRoots makes the roots absolute and drops those that are the same as or below another root,
+// keeping the order they were given in.
+func distinctRoots(roots []string) []string {
+	abs := make([]string, 0, len(roots))
+	for _, root := range roots {
+		if rootAbs, err := filepath.Abs(root); err == nil {
+			root = rootAbs
 		}
+		abs = append(abs, filepath.Clean(root))
+	}
 
-		if maxDepth >= 0 {
-			curDepth := len(strings.Split(filepath.Clean(path), string(os.PathSeparator)))
-			if curDepth-rootDepth > maxDepth {
-				if dEntry.IsDir() {
-					return filepath.SkipDir
-				}
-				return nil
+	var distinct []string
+	for i, root := range abs {
+		covered := false
+		for j, other := range abs {
+			if i == j {
+				continue
+			}
+			// Of two equal roots the first is kept.
+			if (other == root && j < i) || (other != root && within(root, other)) {
+				covered = true
+				break
 			}
 		}
-
-		var info os.FileInfo
-		if followSymlink {
-			info, err = os.Stat(path)
-		} else {
-			info, err = os.Lstat(path)
+		if !covered {
+			distinct = append(distinct, root)
 		}
+	}
+	return distinct
+}
+
+// within tells whether path lies below dir.
+func within(path string, dir string) bool {
+	if dir == "/" {
+		return path != "/"
+	}
+	return strings.HasPrefix(path, dir+"/")
+}
+
+// ProcessAncestors sends a record for each directory above path that is not in seen yet, from / downwards,
+// so the permissions needed to reach path are known. Paths that are sent are added to seen.
+func ProcessAncestors(path string, seen map[string]bool, pipeline *Pipeline) {
+	var ancestors []string
+	for child, dir := path, filepath.Dir(path); dir != child && !seen[dir]; child, dir = dir, filepath.Dir(dir) {
+		ancestors = append(ancestors, dir)
+	}
+
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		seen[ancestors[i]] = true
+		info, err := os.Lstat(ancestors[i])
 		if err != nil {
-			rec := model.FileInfoRecord{Path: path, Err: err.Error()}
-			out <- rec
-			return nil
+			pipeline.Send(model.FileInfoRecord{Path: ancestors[i], Ancestor: true, Err: err.Error()})
+			continue
 		}
-		rec := ProcessPath(path, info, skipACL)
-		out <- rec
-		return nil
-	})
-	close(out)
-	return err
+		pipeline.Add(ancestors[i], info, func(rec *model.FileInfoRecord) {
+			rec.Ancestor = true
+		})
+	}
 }
@@ -0,0 +1,193 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	model "github.com/mykeelium/lshound/model"
+	"github.com/mykeelium/lshound/mounts"
+)
+
+// skippedFSTypes are the filesystems that are left out unless a path on them is included. Pseudo filesystems
+// hold no files of their own and are slow and noisy to walk, network filesystems can hang on a stale server.
+var skippedFSTypes = map[string]bool{
+	"autofs":      true,
+	"binfmt_misc": true,
+	"bpf":         true,
+	"cgroup":      true,
+	"cgroup2":     true,
+	"configfs":    true,
+	"debugfs":     true,
+	"devpts":      true,
+	"devtmpfs":    true,
+	"efivarfs":    true,
+	"fusectl":     true,
+	"hugetlbfs":   true,
+	"mqueue":      true,
+	"nsfs":        true,
+	"proc":        true,
+	"pstore":      true,
+	"rpc_pipefs":  true,
+	"securityfs":  true,
+	"selinuxfs":   true,
+	"sysfs":       true,
+	"tracefs":     true,
+	"9p":          true,
+	"afs":         true,
+	"ceph":        true,
+	"cifs":        true,
+	"fuse.sshfs":  true,
+	"glusterfs":   true,
+	"nfs":         true,
+	"nfs4":        true,
+	"smb3":        true,
+	"smbfs":       true,
+}
+
+// skippedPaths are the container image and layer stores, their contents show up again wherever they are
+// mounted and are left out unless included.
+var skippedPaths = []string{
+	"/var/lib/containerd/io.containerd.snapshotter.v1.overlayfs",
+	"/var/lib/containers/storage/overlay",
+	"/var/lib/docker/overlay2",
+}
+
+// skippedTmpfs are the mount points of the tmpfs mounts that hold the shared memory and runtime files of running
+// processes, such as sockets and keyrings of logged in users. They come and go with the processes and are left
+// out unless included, other tmpfs mounts such as /tmp are collected.
+var skippedTmpfs = []string{
+	"/dev/shm",
+	"/run/shm",
+	"/run/user/*",
+}
+
+// Pattern matches paths by glob or, when prefixed with re:, by regular expression. Globs that contain
+// a / are matched against the whole path, others against the last element only.
+type Pattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func ParsePattern(s string) (Pattern, error) {
+	if expr, ok := strings.CutPrefix(s, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return Pattern{}, fmt.Errorf("invalid pattern %q: %w", s, err)
+		}
+		return Pattern{re: re}, nil
+	}
+	if _, err := filepath.Match(s, ""); err != nil {
+		return Pattern{}, fmt.Errorf("invalid pattern %q: %w", s, err)
+	}
+	return Pattern{glob: s}, nil
+}
+
+func (p Pattern) Match(path string) bool {
+	if p.re != nil {
+		return p.re.MatchString(path)
+	}
+	name := path
+	if !strings.Contains(p.glob, "/") {
+		name = filepath.Base(path)
+	}
+	matched, _ := filepath.Match(p.glob, name)
+	return matched
+}
+
+// Filter decides which paths are collected. A path is left out when it matches an exclude pattern, lies on
+// a skipped filesystem or, with OneFilesystem, on another device than where the collection started, unless
+// it or a directory above it matches an include pattern. Nothing below a left out directory is collected.
+type Filter struct {
+	Include []Pattern
+	Exclude []Pattern
+	// OneFilesystem stays on the device of the starting path.
+	OneFilesystem bool
+}
+
+// Skip tells whether a path given on its own, rather than found by walking, is left out by pattern or
+// filesystem. The directories above it are looked at as well.
+func (f Filter) Skip(path string, table *mounts.Table) bool {
+	if f.includedAbove(path) {
+		return false
+	}
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if f.excluded(dir, table, nil) {
+			return true
+		}
+		if dir == filepath.Dir(dir) {
+			return false
+		}
+	}
+}
+
+// SkipDevice tells whether a path given on its own is left out for not being on device dev.
+func (f Filter) SkipDevice(path string, info os.FileInfo, dev uint64) bool {
+	return f.OneFilesystem && DeviceOf(info) != dev && !f.includedAbove(path)
+}
+
+// includedAbove tells whether the path or a directory above it matches an include pattern.
+func (f Filter) includedAbove(path string) bool {
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if f.included(dir) {
+			return true
+		}
+		if dir == filepath.Dir(dir) {
+			return false
+		}
+	}
+}
+
+func (f Filter) included(path string) bool {
+	return matchAny(f.Include, path)
+}
+
+// excluded tells whether the path itself is left out by pattern or filesystem. It only needs the path and
+// the mount table, so paths are not looked at before it is known they are wanted. The filesystem type of
+// allowed, the mount of a root that was asked for explicitly, is not held against the path.
+func (f Filter) excluded(path string, table *mounts.Table, allowed *model.Mount) bool {
+	if matchAny(f.Exclude, path) {
+		return true
+	}
+	for _, skipped := range skippedPaths {
+		if path == skipped {
+			return true
+		}
+	}
+	// The root filesystem of a container is often an overlay, only overlays mounted elsewhere are skipped.
+	if mount := table.Lookup(path); mount != nil && mount != allowed {
+		return skippedFSTypes[mount.FSType] ||
+			(mount.FSType == "overlay" && mount.MountPoint != "/") ||
+			(mount.FSType == "tmpfs" && runtimeTmpfs(mount.MountPoint))
+	}
+	return false
+}
+
+func runtimeTmpfs(mountPoint string) bool {
+	for _, pattern := range skippedTmpfs {
+		if ok, _ := filepath.Match(pattern, mountPoint); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []Pattern, path string) bool {
+	for _, p := range patterns {
+		if p.Match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceOf returns the device a file is on, or 0 when it is not known.
+func DeviceOf(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev)
+	}
+	return 0
+}
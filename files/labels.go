@@ -0,0 +1,17 @@
+package files
+
+import "strings"
+
+const (
+	selinuxXattr = "security.selinux"
+	smackXattr   = "security.SMACK64"
+)
+
+// readLabel returns an LSM label stored in an xattr, or an empty string when the path has none.
+func readLabel(path string, name string, noFollow bool) (string, error) {
+	data, err := getxattr(path, name, noFollow)
+	if err != nil || data == nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\x00"), nil
+}
@@ -0,0 +1,98 @@
+package files
+
+import (
+	"os"
+	"sync"
+
+	model "github.com/mykeelium/lshound/model"
+)
+
+// Pipeline collects the metadata of paths on a pool of workers. Records are sent on in the order their
+// paths were added, so the output does not depend on how the work was scheduled.
+type Pipeline struct {
+	opts    Options
+	out     chan<- model.FileInfoRecord
+	jobs    chan pipelineJob
+	pending chan chan model.FileInfoRecord
+	workers sync.WaitGroup
+	// queued counts the paths that were added and are not processed yet.
+	queued sync.WaitGroup
+	done   chan struct{}
+}
+
+type pipelineJob struct {
+	path   string
+	info   os.FileInfo
+	amend  func(*model.FileInfoRecord)
+	result chan model.FileInfoRecord
+}
+
+// NewPipeline starts opts.Workers workers, at least one, that send their records to out.
+func NewPipeline(opts Options, out chan<- model.FileInfoRecord) *Pipeline {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// The number of records in flight is bounded, adding blocks once the oldest record holds things up.
+	p := &Pipeline{
+		opts:    opts,
+		out:     out,
+		jobs:    make(chan pipelineJob, workers),
+		pending: make(chan chan model.FileInfoRecord, 4*workers),
+		done:    make(chan struct{}),
+	}
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	go p.emit()
+	return p
+}
+
+// Add queues a path to be processed. amend, when set, is called on the record before it is sent.
+func (p *Pipeline) Add(path string, info os.FileInfo, amend func(*model.FileInfoRecord)) {
+	result := make(chan model.FileInfoRecord, 1)
+	p.queued.Add(1)
+	p.pending <- result
+	p.jobs <- pipelineJob{path: path, info: info, amend: amend, result: result}
+}
+
+// Send queues a record that needs no processing, such as one for a path that could not be stat'ed.
+func (p *Pipeline) Send(rec model.FileInfoRecord) {
+	result := make(chan model.FileInfoRecord, 1)
+	result <- rec
+	p.pending <- result
+}
+
+// Wait blocks until every path added so far is processed, the records may not all be sent yet.
+func (p *Pipeline) Wait() {
+	p.queued.Wait()
+}
+
+// Close waits for every queued record to be sent. The pipeline can't be used afterwards.
+func (p *Pipeline) Close() {
+	close(p.jobs)
+	p.workers.Wait()
+	close(p.pending)
+	<-p.done
+}
+
+func (p *Pipeline) work() {
+	defer p.workers.Done()
+	for job := range p.jobs {
+		rec := ProcessPath(job.path, job.info, p.opts)
+		if job.amend != nil {
+			job.amend(&rec)
+		}
+		job.result <- rec
+		p.queued.Done()
+	}
+}
+
+func (p *Pipeline) emit() {
+	for result := range p.pending {
+		p.out <- <-result
+	}
+	close(p.done)
+}
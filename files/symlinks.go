@@ -0,0 +1,45 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	model "github.com/mykeelium/lshound/model"
+)
+
+// maxLinkHops is the number of links resolved before giving up, the same limit the kernel uses.
+const maxLinkHops = 40
+
+// resolveLinkChain follows a symlink one link at a time, resolving each target relative to the directory of
+// the link that holds it. The chain ends at the first target that is not a symlink or does not exist.
+func resolveLinkChain(path string, target string) []model.LinkHop {
+	var chain []model.LinkHop
+	for len(chain) < maxLinkHops {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		hop := model.LinkHop{Path: filepath.Clean(target)}
+
+		info, err := os.Lstat(hop.Path)
+		if err != nil {
+			hop.Missing = os.IsNotExist(err)
+			return append(chain, hop)
+		}
+		hop.Type = fileType(info.Mode())
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			hop.Dev = uint64(stat.Dev)
+			hop.INode = uint64(stat.Ino)
+		}
+		chain = append(chain, hop)
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			break
+		}
+		path = hop.Path
+		if target, err = os.Readlink(path); err != nil {
+			break
+		}
+	}
+	return chain
+}
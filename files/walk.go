@@ -0,0 +1,279 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+
+	model "github.com/mykeelium/lshound/model"
+)
+
+// walker walks down a tree of directories, and when following symlinks also down the directories that
+// symlinks point to. Every directory is walked once, which also breaks symlink loops.
+type walker struct {
+	opts     Options
+	pipeline *Pipeline
+	// seen holds the directories that have been sent, whether walked or sent as an ancestor.
+	seen map[string]bool
+	// visited holds the directories that have been walked, by device and inode.
+	visited map[fileKey]bool
+	// device is the device of the root, which the walk stays on with Filter.OneFilesystem.
+	device uint64
+	// rootMount is the mount of the root, which is walked even when its filesystem type is skipped.
+	rootMount *model.Mount
+	// trackFiles adds files to seen as well, for when the same file can be asked for more than once.
+	trackFiles bool
+	// targets holds what the collected symlinks point to, or the first directory above a missing target that
+	// exists, by device and inode. It is filled in by the
+	// workers, the targets that turn out not to be collected are sent as placeholders at the end.
+	targets   map[fileKey]model.LinkHop
+	targetsMu sync.Mutex
+}
+
+type fileKey struct {
+	dev   uint64
+	inode uint64
+}
+
+func newWalker(opts Options, pipeline *Pipeline) *walker {
+	return &walker{
+		opts:     opts,
+		pipeline: pipeline,
+		seen:     map[string]bool{},
+		visited:  map[fileKey]bool{},
+		targets:  map[fileKey]model.LinkHop{},
+	}
+}
+
+// visit sends the record for path and walks down it when it is a directory. logicalPath is the path the
+// walk reached it through, which differs from path below a followed symlink. included is set below a
+// directory that matched an include pattern, where nothing is left out.
+func (w *walker) visit(path string, logicalPath string, info os.FileInfo, depth int, included bool) {
+	if info.IsDir() {
+		w.walkDir(path, logicalPath, info, depth, included)
+		return
+	}
+
+	if w.trackFiles {
+		if w.seen[path] {
+			return
+		}
+		w.seen[path] = true
+	}
+	w.send(path, logicalPath, info, nil)
+	if !w.opts.FollowSymlink || info.Mode()&os.ModeSymlink == 0 {
+		return
+	}
+
+	// The directory a symlink points to is walked under its real path, so it and the directories above it
+	// are placed in the tree where they actually are. Links to anything else are left to their PointsTo edges.
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return
+	}
+	included = included || w.opts.Filter.included(target)
+	if !included && w.opts.Filter.excluded(target, w.opts.Mounts, w.rootMount) {
+		return
+	}
+	targetInfo, err := os.Stat(target)
+	if err != nil || !targetInfo.IsDir() || w.otherDevice(targetInfo, included) {
+		return
+	}
+	ProcessAncestors(target, w.seen, w.pipeline)
+	w.walkDir(target, logicalPath, targetInfo, depth, included)
+}
+
+func (w *walker) walkDir(path string, logicalPath string, info os.FileInfo, depth int, included bool) {
+	// Directories are only marked as walked once their entries are read, one that was cut off by the depth
+	// can still be walked when it is reached again with depth to spare.
+	var entries []os.DirEntry
+	var readErr error
+	read := w.opts.MaxDepth < 0 || depth < w.opts.MaxDepth
+	if read {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			key := fileKey{dev: uint64(stat.Dev), inode: uint64(stat.Ino)}
+			if w.visited[key] {
+				return
+			}
+			w.visited[key] = true
+		}
+		entries, readErr = os.ReadDir(path)
+	}
+
+	// Directories above a followed symlink may already have been sent as an ancestor.
+	if !w.seen[path] {
+		w.seen[path] = true
+		w.send(path, logicalPath, info, readErr)
+	}
+
+	for _, entry := range entries {
+		childPath := filepath.Join(path, entry.Name())
+		// Patterns and filesystems are checked before the child is looked at, a stale network mount can
+		// hang on that alone.
+		childIncluded := included || w.opts.Filter.included(childPath)
+		if !childIncluded && w.opts.Filter.excluded(childPath, w.opts.Mounts, w.rootMount) {
+			continue
+		}
+		childInfo, err := os.Lstat(childPath)
+		if err != nil {
+			w.pipeline.Send(model.FileInfoRecord{Path: childPath, Err: err.Error()})
+			continue
+		}
+		if w.otherDevice(childInfo, childIncluded) {
+			// As with find -xdev, a mount point is collected but not walked.
+			w.send(childPath, filepath.Join(logicalPath, entry.Name()), childInfo, nil)
+			continue
+		}
+		w.visit(childPath, filepath.Join(logicalPath, entry.Name()), childInfo, depth+1, childIncluded)
+	}
+	// A walked directory is never walked again, so nothing more is sent for it.
+	if read {
+		w.pipeline.Send(model.FileInfoRecord{Path: path, DirDone: true})
+	}
+}
+
+// otherDevice tells whether a path is left out for being on another device than the root.
+func (w *walker) otherDevice(info os.FileInfo, included bool) bool {
+	return w.opts.Filter.OneFilesystem && !included && DeviceOf(info) != w.device
+}
+
+func (w *walker) send(path string, logicalPath string, info os.FileInfo, err error) {
+	w.pipeline.Add(path, info, func(rec *model.FileInfoRecord) {
+		if logicalPath != path {
+			rec.LogicalPath = logicalPath
+		}
+		w.addTargets(rec.LinkChain)
+		appendErr(rec, err)
+	})
+}
+
+func (w *walker) addTargets(chain []model.LinkHop) {
+	if len(chain) == 0 {
+		return
+	}
+	w.targetsMu.Lock()
+	defer w.targetsMu.Unlock()
+	for _, hop := range chain {
+		// Missing targets are placed below the first directory above them that exists.
+		if hop.Missing {
+			hop = existingAncestor(hop.Path)
+		}
+		if hop.INode == 0 {
+			continue
+		}
+		key := fileKey{dev: hop.Dev, inode: hop.INode}
+		if _, ok := w.targets[key]; !ok {
+			w.targets[key] = hop
+		}
+	}
+}
+
+// existingAncestor returns the first directory above path that exists, without its inode when there is none.
+func existingAncestor(path string) model.LinkHop {
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		info, err := os.Lstat(dir)
+		if err == nil {
+			hop := model.LinkHop{Path: dir, Type: fileType(info.Mode())}
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+				hop.Dev, hop.INode = uint64(stat.Dev), uint64(stat.Ino)
+			}
+			return hop
+		}
+		if !os.IsNotExist(err) || dir == filepath.Dir(dir) {
+			return model.LinkHop{Path: dir}
+		}
+	}
+}
+
+// sendPlaceholders sends a placeholder record for every symlink target that was not collected, so that the
+// PointsTo edges to it end at a node. It waits for the records sent so far to be processed first.
+func (w *walker) sendPlaceholders() {
+	w.pipeline.Wait()
+	hops := make([]model.LinkHop, 0, len(w.targets))
+	for _, hop := range w.targets {
+		if !w.collected(hop) {
+			hops = append(hops, hop)
+		}
+	}
+	clear(w.targets)
+	sort.Slice(hops, func(i, j int) bool { return hops[i].Path < hops[j].Path })
+	for _, hop := range hops {
+		w.pipeline.Send(model.FileInfoRecord{
+			Path:        hop.Path,
+			Type:        hop.Type,
+			Dev:         hop.Dev,
+			INode:       hop.INode,
+			Placeholder: true,
+		})
+	}
+}
+
+// collected tells whether a symlink target was sent, either on its own or as an entry of a directory that
+// was walked and that the filters let it through in.
+func (w *walker) collected(hop model.LinkHop) bool {
+	if w.seen[hop.Path] {
+		return true
+	}
+	info, err := os.Stat(filepath.Dir(hop.Path))
+	if err != nil {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || !w.visited[fileKey{dev: uint64(stat.Dev), inode: uint64(stat.Ino)}] {
+		return false
+	}
+	return w.opts.Filter.includedAbove(hop.Path) || !w.opts.Filter.excluded(hop.Path, w.opts.Mounts, w.rootMount)
+}
+
+// Collector sends paths given one at a time, such as those read from stdin, along with the directories above
+// them. It shares the bookkeeping of a walk, so nothing is sent twice, and paths are filtered the same way.
+type Collector struct {
+	walker *walker
+	// deviceSet is set once the device of the first path is known, the paths are kept to it with -xdev.
+	deviceSet bool
+}
+
+func NewCollector(opts Options, out chan<- model.FileInfoRecord) *Collector {
+	w := newWalker(opts, NewPipeline(opts, out))
+	w.trackFiles = true
+	return &Collector{walker: w}
+}
+
+// Walk sends path and walks down it to maxDepth, where -1 is unlimited, following symlinks if asked to.
+func (c *Collector) Walk(path string, maxDepth int, follow bool) {
+	w := c.walker
+	if w.opts.Filter.Skip(path, w.opts.Mounts) {
+		return
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		if !w.seen[path] {
+			w.seen[path] = true
+			ProcessAncestors(path, w.seen, w.pipeline)
+			w.pipeline.Send(model.FileInfoRecord{Path: path, Err: err.Error()})
+		}
+		return
+	}
+	if !c.deviceSet {
+		w.device, c.deviceSet = DeviceOf(info), true
+	}
+	if w.opts.Filter.SkipDevice(path, info, w.device) {
+		return
+	}
+	// Directories are walked again when asked to go deeper, the walk itself skips what it already sent.
+	if w.seen[path] && (!info.IsDir() || maxDepth == 0) {
+		return
+	}
+
+	ProcessAncestors(path, w.seen, w.pipeline)
+	w.opts.MaxDepth, w.opts.FollowSymlink = maxDepth, follow
+	w.visit(path, path, info, 0, w.opts.Filter.includedAbove(path))
+}
+
+// Close waits for every path to be sent. The channel is left open.
+func (c *Collector) Close() {
+	c.walker.sendPlaceholders()
+	c.walker.pipeline.Close()
+}
@@ -0,0 +1,70 @@
+//go:build linux
+
+package files
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// getxattr returns the value of the named extended attribute, or nil when the path does not carry it.
+// When noFollow is set a symlink's own attributes are read rather than those of its target.
+func getxattr(path string, name string, noFollow bool) ([]byte, error) {
+	for {
+		size, err := rawGetxattr(path, name, nil, noFollow)
+		if err != nil {
+			return nil, xattrError(err)
+		}
+		if size == 0 {
+			return []byte{}, nil
+		}
+
+		buf := make([]byte, size)
+		size, err = rawGetxattr(path, name, buf, noFollow)
+		if err == syscall.ERANGE {
+			// The attribute grew between the two calls, size it again.
+			continue
+		}
+		if err != nil {
+			return nil, xattrError(err)
+		}
+		return buf[:size], nil
+	}
+}
+
+func rawGetxattr(path string, name string, dest []byte, noFollow bool) (int, error) {
+	if !noFollow {
+		return syscall.Getxattr(path, name, dest)
+	}
+
+	pathPtr, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	namePtr, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	var destPtr unsafe.Pointer
+	if len(dest) > 0 {
+		destPtr = unsafe.Pointer(&dest[0])
+	}
+	size, _, errno := syscall.Syscall6(syscall.SYS_LGETXATTR,
+		uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(namePtr)),
+		uintptr(destPtr),
+		uintptr(len(dest)),
+		0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(size), nil
+}
+
+func xattrError(err error) error {
+	switch err {
+	case syscall.ENODATA, syscall.ENOTSUP, syscall.EPERM:
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package files
+
+// getxattr is only implemented on Linux, other systems report no extended attributes.
+func getxattr(path string, name string, noFollow bool) ([]byte, error) {
+	return nil, nil
+}
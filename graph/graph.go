@@ -1,2 +1,30 @@
 // Package graph handles the graph methods and data
 package graph
+
+import "fmt"
+
+// EveryoneID is the group every user is a member of. The other bits of a file are granted to it, though
+// they only apply to users that are neither the owner nor in a group the file matches.
+const EveryoneID = "gid-everyone"
+
+func UserID(uid uint32) string {
+	return fmt.Sprintf("uid-%d", uid)
+}
+
+func GroupID(gid uint32) string {
+	return fmt.Sprintf("gid-%d", gid)
+}
+
+// FileID identifies a file by device and inode, inode numbers are only unique within one filesystem.
+func FileID(dev uint64, inode uint64) string {
+	return fmt.Sprintf("inode-%d-%d", dev, inode)
+}
+
+// PathID identifies a node by path, used for directories that were not collected themselves.
+func PathID(path string) string {
+	return fmt.Sprintf("path-%s", path)
+}
+
+func CapabilityID(name string) string {
+	return fmt.Sprintf("cap-%s", name)
+}
@@ -0,0 +1,187 @@
+package graph
+
+import (
+	"os"
+
+	model "github.com/mykeelium/lshound/model"
+)
+
+// Permission bits as they appear in each class of the mode and in ACL entries.
+const (
+	PermExecute uint8 = 0o1
+	PermWrite   uint8 = 0o2
+	PermRead    uint8 = 0o4
+)
+
+// Sources a Grant can come from.
+const (
+	SourceMode = "mode"
+	SourceACL  = "acl"
+	// SourceMask is the group class of a file known to have an ACL whose entries are not, such as from an ls
+	// listing. Those bits are the mask, the most the owning group may hold rather than what it holds.
+	SourceMask = "mask"
+)
+
+// Grant is the access a single principal effectively holds on a file.
+type Grant struct {
+	Principal string
+	Perm      uint8
+	Source    string
+}
+
+// EffectiveGrants evaluates the mode bits and access ACL of a file the way the kernel does. When the file
+// has an ACL with a mask, the group class bits of the mode are the mask, so the owning group, named users
+// and named groups only hold what their ACL entry grants within that mask.
+func EffectiveGrants(file model.FileInfoRecord) []Grant {
+	grants := []Grant{
+		{Principal: UserID(file.UID), Perm: ownerPerm(file.Mode), Source: SourceMode},
+	}
+
+	mask, hasMask := aclMask(file.ACLEntries)
+	if !hasMask {
+		grants = append(grants, Grant{Principal: GroupID(file.GID), Perm: groupPerm(file.Mode), Source: groupSource(file)})
+	}
+	for _, entry := range file.ACLEntries {
+		switch entry.Tag {
+		case model.ACLTagGroupObj:
+			if hasMask {
+				grants = append(grants, Grant{Principal: GroupID(file.GID), Perm: entry.Perm & mask, Source: SourceACL})
+			}
+		case model.ACLTagUser:
+			grants = append(grants, Grant{Principal: UserID(entry.ID), Perm: entry.Perm & mask, Source: SourceACL})
+		case model.ACLTagGroup:
+			grants = append(grants, Grant{Principal: GroupID(entry.ID), Perm: entry.Perm & mask, Source: SourceACL})
+		}
+	}
+
+	return append(grants, Grant{Principal: EveryoneID, Perm: otherPerm(file.Mode), Source: SourceMode})
+}
+
+// InheritedGrants evaluates the default ACL of a directory, giving the access principals will hold on
+// files created inside it. The default mask limits named entries and the group entry like it does for access ACLs.
+func InheritedGrants(dir model.FileInfoRecord) []Grant {
+	mask, _ := aclMask(dir.DefaultACL)
+
+	var grants []Grant
+	for _, entry := range dir.DefaultACL {
+		switch entry.Tag {
+		case model.ACLTagUser:
+			grants = append(grants, Grant{Principal: UserID(entry.ID), Perm: entry.Perm & mask, Source: SourceACL})
+		case model.ACLTagGroup:
+			grants = append(grants, Grant{Principal: GroupID(entry.ID), Perm: entry.Perm & mask, Source: SourceACL})
+		case model.ACLTagGroupObj:
+			// New files only inherit the directory's group when it is setgid, otherwise the group is the creator's.
+			if dir.SetGID {
+				grants = append(grants, Grant{Principal: GroupID(dir.GID), Perm: entry.Perm & mask, Source: SourceACL})
+			}
+		case model.ACLTagOther:
+			grants = append(grants, Grant{Principal: EveryoneID, Perm: entry.Perm, Source: SourceACL})
+		}
+	}
+	return grants
+}
+
+// aclMask returns the mask entry of an ACL, or all permissions when there is none.
+func aclMask(entries []model.ACLEntry) (uint8, bool) {
+	for _, entry := range entries {
+		if entry.Tag == model.ACLTagMask {
+			return entry.Perm, true
+		}
+	}
+	return PermRead | PermWrite | PermExecute, false
+}
+
+// groupSource tells where the group class bits of a file without a known mask come from.
+func groupSource(file model.FileInfoRecord) string {
+	if file.ACL && len(file.ACLEntries) == 0 {
+		return SourceMask
+	}
+	return SourceMode
+}
+
+func ownerPerm(mode os.FileMode) uint8 {
+	return uint8(mode>>6) & 0o7
+}
+
+func groupPerm(mode os.FileMode) uint8 {
+	return uint8(mode>>3) & 0o7
+}
+
+func otherPerm(mode os.FileMode) uint8 {
+	return uint8(mode) & 0o7
+}
+
+// Excluded returns the principals that hold a grant of their own on the file lacking perm. The kernel checks
+// them before the other bits, so they don't get perm through Everyone even though they are members of it.
+func Excluded(grants []Grant, perm uint8) []string {
+	var excluded []string
+	for _, grant := range grants {
+		if grant.Principal != EveryoneID && grant.Perm&perm != perm {
+			excluded = append(excluded, grant.Principal)
+		}
+	}
+	return excluded
+}
+
+// Identity is what the kernel checks a user's access with: the UID and every group the user is in.
+type Identity struct {
+	UID  uint32
+	GIDs map[uint32]bool
+	// SearchAll is set for root and holders of CAP_DAC_READ_SEARCH, who search every directory regardless
+	// of its permissions.
+	SearchAll bool
+}
+
+// UserGrants evaluates the access of each user on a file. Unlike EffectiveGrants, this follows the
+// precedence of the kernel: the owner only gets the owner bits, any matching group entry stops the
+// check before the other bits are looked at, and only then do the other bits apply. A file with mode
+// 0077 is therefore not readable by its owner. Users without any access are left out.
+func UserGrants(file model.FileInfoRecord, identities []Identity) []Grant {
+	var grants []Grant
+	for _, identity := range identities {
+		perm, source := userPerm(file, identity)
+		if perm != 0 {
+			grants = append(grants, Grant{Principal: UserID(identity.UID), Perm: perm, Source: source})
+		}
+	}
+	return grants
+}
+
+func userPerm(file model.FileInfoRecord, identity Identity) (uint8, string) {
+	if identity.UID == file.UID {
+		return ownerPerm(file.Mode), SourceMode
+	}
+
+	mask, hasMask := aclMask(file.ACLEntries)
+	for _, entry := range file.ACLEntries {
+		if entry.Tag == model.ACLTagUser && entry.ID == identity.UID {
+			return entry.Perm & mask, SourceACL
+		}
+	}
+
+	// Every matching group entry is considered, the user holds the union of what they grant.
+	matched := false
+	var perm uint8
+	source := SourceMode
+	if identity.GIDs[file.GID] {
+		matched = true
+		perm = groupPerm(file.Mode)
+		source = groupSource(file)
+	}
+	if hasMask {
+		perm = 0
+		for _, entry := range file.ACLEntries {
+			if (entry.Tag == model.ACLTagGroupObj && identity.GIDs[file.GID]) ||
+				(entry.Tag == model.ACLTagGroup && identity.GIDs[entry.ID]) {
+				matched = true
+				perm |= entry.Perm & mask
+				source = SourceACL
+			}
+		}
+	}
+	if matched {
+		return perm, source
+	}
+
+	return otherPerm(file.Mode), SourceMode
+}
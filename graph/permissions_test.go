@@ -0,0 +1,219 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+
+	model "github.com/mykeelium/lshound/model"
+)
+
+func acl(entries ...model.ACLEntry) []model.ACLEntry {
+	return entries
+}
+
+func entry(tag string, id uint32, perm uint8) model.ACLEntry {
+	return model.ACLEntry{Tag: tag, ID: id, Perm: perm}
+}
+
+func TestEffectiveGrants(t *testing.T) {
+	tests := []struct {
+		name string
+		file model.FileInfoRecord
+		want []Grant
+	}{
+		{
+			name: "mode only",
+			file: model.FileInfoRecord{UID: 1000, GID: 100, Mode: 0o077},
+			want: []Grant{
+				{Principal: "uid-1000", Perm: 0, Source: SourceMode},
+				{Principal: "gid-100", Perm: 0o7, Source: SourceMode},
+				{Principal: EveryoneID, Perm: 0o7, Source: SourceMode},
+			},
+		},
+		{
+			name: "named user limited by the mask",
+			file: model.FileInfoRecord{
+				UID: 0, GID: 0, Mode: 0o640,
+				ACLEntries: acl(
+					entry(model.ACLTagUserObj, 0, 0o6),
+					entry(model.ACLTagUser, 1000, 0o7),
+					entry(model.ACLTagGroupObj, 0, 0o4),
+					entry(model.ACLTagMask, 0, 0o4),
+					entry(model.ACLTagOther, 0, 0),
+				),
+			},
+			want: []Grant{
+				{Principal: "uid-0", Perm: 0o6, Source: SourceMode},
+				{Principal: "uid-1000", Perm: 0o4, Source: SourceACL},
+				{Principal: "gid-0", Perm: 0o4, Source: SourceACL},
+				{Principal: EveryoneID, Perm: 0, Source: SourceMode},
+			},
+		},
+		{
+			name: "group_obj limited by the mask rather than the mode",
+			file: model.FileInfoRecord{
+				UID: 0, GID: 50, Mode: 0o750,
+				ACLEntries: acl(
+					entry(model.ACLTagUserObj, 0, 0o7),
+					entry(model.ACLTagGroupObj, 0, 0o7),
+					entry(model.ACLTagGroup, 60, 0o6),
+					entry(model.ACLTagMask, 0, 0o5),
+					entry(model.ACLTagOther, 0, 0),
+				),
+			},
+			want: []Grant{
+				{Principal: "uid-0", Perm: 0o7, Source: SourceMode},
+				{Principal: "gid-50", Perm: 0o5, Source: SourceACL},
+				{Principal: "gid-60", Perm: 0o4, Source: SourceACL},
+				{Principal: EveryoneID, Perm: 0, Source: SourceMode},
+			},
+		},
+		{
+			name: "ACL without its entries",
+			file: model.FileInfoRecord{UID: 0, GID: 50, Mode: 0o770, ACL: true},
+			want: []Grant{
+				{Principal: "uid-0", Perm: 0o7, Source: SourceMode},
+				{Principal: "gid-50", Perm: 0o7, Source: SourceMask},
+				{Principal: EveryoneID, Perm: 0, Source: SourceMode},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EffectiveGrants(tt.file); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EffectiveGrants() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserPerm(t *testing.T) {
+	groups := func(gids ...uint32) map[uint32]bool {
+		set := map[uint32]bool{}
+		for _, gid := range gids {
+			set[gid] = true
+		}
+		return set
+	}
+
+	tests := []struct {
+		name       string
+		file       model.FileInfoRecord
+		identity   Identity
+		wantPerm   uint8
+		wantSource string
+	}{
+		{
+			name:       "owner only gets the owner bits",
+			file:       model.FileInfoRecord{UID: 1000, GID: 100, Mode: 0o077},
+			identity:   Identity{UID: 1000, GIDs: groups(100)},
+			wantPerm:   0,
+			wantSource: SourceMode,
+		},
+		{
+			name:       "group bits stop the check before other",
+			file:       model.FileInfoRecord{UID: 0, GID: 100, Mode: 0o604},
+			identity:   Identity{UID: 1000, GIDs: groups(100)},
+			wantPerm:   0,
+			wantSource: SourceMode,
+		},
+		{
+			name:       "other bits for users in none of the classes",
+			file:       model.FileInfoRecord{UID: 0, GID: 100, Mode: 0o604},
+			identity:   Identity{UID: 1000, GIDs: groups(200)},
+			wantPerm:   0o4,
+			wantSource: SourceMode,
+		},
+		{
+			name: "named user limited by the mask",
+			file: model.FileInfoRecord{
+				UID: 0, GID: 0, Mode: 0o640,
+				ACLEntries: acl(
+					entry(model.ACLTagUserObj, 0, 0o6),
+					entry(model.ACLTagUser, 1000, 0o7),
+					entry(model.ACLTagGroupObj, 0, 0o4),
+					entry(model.ACLTagMask, 0, 0o4),
+					entry(model.ACLTagOther, 0, 0o4),
+				),
+			},
+			identity:   Identity{UID: 1000, GIDs: groups(0)},
+			wantPerm:   0o4,
+			wantSource: SourceACL,
+		},
+		{
+			name: "group_obj limited by the mask",
+			file: model.FileInfoRecord{
+				UID: 0, GID: 50, Mode: 0o750,
+				ACLEntries: acl(
+					entry(model.ACLTagUserObj, 0, 0o7),
+					entry(model.ACLTagGroupObj, 0, 0o7),
+					entry(model.ACLTagMask, 0, 0o5),
+					entry(model.ACLTagOther, 0, 0),
+				),
+			},
+			identity:   Identity{UID: 1000, GIDs: groups(50)},
+			wantPerm:   0o5,
+			wantSource: SourceACL,
+		},
+		{
+			name: "every matching group entry adds up",
+			file: model.FileInfoRecord{
+				UID: 0, GID: 50, Mode: 0o770,
+				ACLEntries: acl(
+					entry(model.ACLTagUserObj, 0, 0o7),
+					entry(model.ACLTagGroupObj, 0, 0),
+					entry(model.ACLTagGroup, 60, 0o4),
+					entry(model.ACLTagGroup, 70, 0o2),
+					entry(model.ACLTagGroup, 80, 0o1),
+					entry(model.ACLTagMask, 0, 0o7),
+					entry(model.ACLTagOther, 0, 0o7),
+				),
+			},
+			identity:   Identity{UID: 1000, GIDs: groups(50, 60, 70)},
+			wantPerm:   0o6,
+			wantSource: SourceACL,
+		},
+		{
+			name: "matching group entries without permissions stop the check",
+			file: model.FileInfoRecord{
+				UID: 0, GID: 50, Mode: 0o707,
+				ACLEntries: acl(
+					entry(model.ACLTagUserObj, 0, 0o7),
+					entry(model.ACLTagGroupObj, 0, 0),
+					entry(model.ACLTagGroup, 60, 0),
+					entry(model.ACLTagMask, 0, 0o7),
+					entry(model.ACLTagOther, 0, 0o7),
+				),
+			},
+			identity:   Identity{UID: 1000, GIDs: groups(60)},
+			wantPerm:   0,
+			wantSource: SourceACL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perm, source := userPerm(tt.file, tt.identity)
+			if perm != tt.wantPerm || source != tt.wantSource {
+				t.Errorf("userPerm() = %o, %s, want %o, %s", perm, source, tt.wantPerm, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestUserGrants(t *testing.T) {
+	file := model.FileInfoRecord{UID: 1000, GID: 100, Mode: 0o077}
+	identities := []Identity{
+		{UID: 1000, GIDs: map[uint32]bool{100: true}},
+		{UID: 1001, GIDs: map[uint32]bool{100: true}},
+		{UID: 1002, GIDs: map[uint32]bool{200: true}},
+	}
+	want := []Grant{
+		{Principal: "uid-1001", Perm: 0o7, Source: SourceMode},
+		{Principal: "uid-1002", Perm: 0o7, Source: SourceMode},
+	}
+	if got := UserGrants(file, identities); !reflect.DeepEqual(got, want) {
+		t.Errorf("UserGrants() = %v, want %v", got, want)
+	}
+}
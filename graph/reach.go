@@ -0,0 +1,71 @@
+package graph
+
+import model "github.com/mykeelium/lshound/model"
+
+// Reach is the set of users that can search every directory on the way down to a path. Each user is
+// judged the way the kernel would, through their own UID and every group they are in.
+type Reach struct {
+	everyone bool
+	uids     map[uint32]bool
+}
+
+// Everyone is the reach of a path whose ancestors are unknown, nobody is assumed to be stopped on the way.
+func Everyone() Reach {
+	return Reach{everyone: true}
+}
+
+// Narrow returns the users of r that can also search dir. When nobody is stopped by dir, r itself is
+// returned rather than a copy, so that a reach is shared down the tree until it changes.
+func (r Reach) Narrow(dir model.FileInfoRecord, identities []Identity) Reach {
+	stopped := 0
+	kept := 0
+	for _, identity := range identities {
+		if !r.Has(identity.UID) {
+			continue
+		}
+		if searches(dir, identity) {
+			kept++
+		} else {
+			stopped++
+		}
+	}
+	if stopped == 0 {
+		return r
+	}
+
+	reach := Reach{uids: make(map[uint32]bool, kept)}
+	for _, identity := range identities {
+		if r.Has(identity.UID) && searches(dir, identity) {
+			reach.uids[identity.UID] = true
+		}
+	}
+	return reach
+}
+
+func searches(dir model.FileInfoRecord, identity Identity) bool {
+	if identity.SearchAll {
+		return true
+	}
+	perm, _ := userPerm(dir, identity)
+	return perm&PermExecute != 0
+}
+
+func (r Reach) Has(uid uint32) bool {
+	return r.everyone || r.uids[uid]
+}
+
+// Union returns the users that are in r or in other, such as those that can get to a hard-linked file through
+// any of its links.
+func (r Reach) Union(other Reach) Reach {
+	if r.everyone || other.everyone {
+		return Everyone()
+	}
+	union := Reach{uids: make(map[uint32]bool, len(r.uids)+len(other.uids))}
+	for uid := range r.uids {
+		union.uids[uid] = true
+	}
+	for uid := range other.uids {
+		union.uids[uid] = true
+	}
+	return union
+}
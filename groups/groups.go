@@ -12,7 +12,12 @@ import (
 )
 
 func GetAllGroups() ([]model.Group, error) {
-	file, err := os.Open("/etc/group")
+	return ReadGroups("/etc/group")
+}
+
+// ReadGroups reads the groups from a file in the format of /etc/group, such as a copy taken from another system.
+func ReadGroups(path string) ([]model.Group, error) {
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,154 @@
+// Package identity indexes the users and groups of the system, so that owners and memberships can be resolved
+// without an NSS lookup for every file.
+package identity
+
+import (
+	"os/user"
+	"strconv"
+	"sync"
+
+	model "github.com/mykeelium/lshound/model"
+)
+
+// Index resolves UIDs and GIDs to names and users to the groups they are in. IDs that are not in the
+// index, such as users from LDAP, are looked up through NSS once and cached. It is safe for concurrent use.
+type Index struct {
+	users       []model.User
+	groups      []model.Group
+	userNames   map[uint32]string
+	groupNames  map[uint32]string
+	uidsByName  map[string]uint32
+	gidsByName  map[string]uint32
+	memberships map[uint32]map[uint32]bool
+	// offline indexes describe another system, whose IDs mean nothing to the local NSS.
+	offline bool
+
+	mu        sync.RWMutex
+	nssUsers  map[uint32]string
+	nssGroups map[uint32]string
+}
+
+func NewIndex(users []model.User, groups []model.Group) *Index {
+	index := &Index{
+		users:       users,
+		groups:      groups,
+		userNames:   map[uint32]string{},
+		groupNames:  map[uint32]string{},
+		uidsByName:  map[string]uint32{},
+		gidsByName:  map[string]uint32{},
+		memberships: map[uint32]map[uint32]bool{},
+		nssUsers:    map[uint32]string{},
+		nssGroups:   map[uint32]string{},
+	}
+
+	// The first entry for an ID or name wins, as it does for NSS.
+	for _, u := range users {
+		if _, ok := index.userNames[u.UID]; !ok {
+			index.userNames[u.UID] = u.Username
+		}
+		if _, ok := index.uidsByName[u.Username]; !ok {
+			index.uidsByName[u.Username] = u.UID
+		}
+		if index.memberships[u.UID] == nil {
+			index.memberships[u.UID] = map[uint32]bool{}
+		}
+		index.memberships[u.UID][u.GID] = true
+	}
+	for _, g := range groups {
+		if _, ok := index.groupNames[g.GID]; !ok {
+			index.groupNames[g.GID] = g.Name
+		}
+		if _, ok := index.gidsByName[g.Name]; !ok {
+			index.gidsByName[g.Name] = g.GID
+		}
+		for _, member := range g.Members {
+			if uid, ok := index.uidsByName[member]; ok {
+				index.memberships[uid][g.GID] = true
+			}
+		}
+	}
+	return index
+}
+
+// NewOfflineIndex indexes the users and groups of another system, such as from copies of its passwd and
+// group files. IDs that are not in the index are not looked up.
+func NewOfflineIndex(users []model.User, groups []model.Group) *Index {
+	index := NewIndex(users, groups)
+	index.offline = true
+	return index
+}
+
+func (i *Index) Users() []model.User {
+	return i.users
+}
+
+func (i *Index) Groups() []model.Group {
+	return i.groups
+}
+
+// UserID returns the UID of the named user.
+func (i *Index) UserID(name string) (uint32, bool) {
+	uid, ok := i.uidsByName[name]
+	return uid, ok
+}
+
+// GroupID returns the GID of the named group.
+func (i *Index) GroupID(name string) (uint32, bool) {
+	gid, ok := i.gidsByName[name]
+	return gid, ok
+}
+
+// GroupsOf returns the GIDs of the primary and supplementary groups of a user. The map must not be changed.
+func (i *Index) GroupsOf(uid uint32) map[uint32]bool {
+	return i.memberships[uid]
+}
+
+// UserName returns the name of a UID, or an empty string when it has none.
+func (i *Index) UserName(uid uint32) string {
+	if name, ok := i.userNames[uid]; ok {
+		return name
+	}
+	return i.lookup(uid, i.nssUsers, func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+}
+
+// GroupName returns the name of a GID, or an empty string when it has none.
+func (i *Index) GroupName(gid uint32) string {
+	if name, ok := i.groupNames[gid]; ok {
+		return name
+	}
+	return i.lookup(gid, i.nssGroups, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+}
+
+// lookup resolves an ID through NSS, caching failed lookups as well so unknown IDs are only looked up once.
+func (i *Index) lookup(id uint32, cache map[uint32]string, resolve func(string) (string, error)) string {
+	if i.offline {
+		return ""
+	}
+	i.mu.RLock()
+	name, ok := cache[id]
+	i.mu.RUnlock()
+	if ok {
+		return name
+	}
+
+	name, err := resolve(strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		name = ""
+	}
+	i.mu.Lock()
+	cache[id] = name
+	i.mu.Unlock()
+	return name
+}
@@ -4,66 +4,158 @@ import (
 	"bufio"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/mykeelium/lshound/capabilities"
 	lshound_files "github.com/mykeelium/lshound/files"
 	lshound_groups "github.com/mykeelium/lshound/groups"
+	"github.com/mykeelium/lshound/identity"
 	model "github.com/mykeelium/lshound/model"
+	"github.com/mykeelium/lshound/mounts"
+	"github.com/mykeelium/lshound/offline"
 	lshound_users "github.com/mykeelium/lshound/users"
 	"github.com/mykeelium/lshound/writer"
 )
 
 var (
-	startPath      string
+	startPaths     stringList
 	baseCollection bool
 	skipACL        bool
 	followSymlink  bool
 	maxDepth       int
 	outputToStdOut bool
 	fileChannel    chan model.FileInfoRecord
+	fileOpts       lshound_files.Options
 	outputName     string
+	workers        int
+	pruneReach     bool
+	perUser        bool
+	skipProcCaps   bool
+	compression    string
+	chunkItems     int
+	chunkSize      string
+	includes       stringList
+	excludes       stringList
+	oneFilesystem  bool
+	stdinFormat    string
+	passwdPath     string
+	groupPath      string
+	offlineInput   offline.Sources
 	wg             sync.WaitGroup
 )
 
+// Formats of the paths read from stdin.
+const (
+	stdinLines = "lines"
+	stdinNull  = "null"
+	stdinJSONL = "jsonl"
+)
+
+// stdinEntry is a line of JSON-lines input. The path is collected on its own unless Depth asks for the
+// directories below it to be walked as well, -1 being unlimited. Follow descends into symlinked directories.
+type stdinEntry struct {
+	Path   string `json:"path"`
+	Depth  int    `json:"depth"`
+	Follow bool   `json:"follow"`
+}
+
 func fromStdin() {
 	reader := bufio.NewReader(os.Stdin)
+	collector := lshound_files.NewCollector(fileOpts, fileChannel)
+	delimiter := byte('\n')
+	if stdinFormat == stdinNull {
+		delimiter = 0
+	}
 	for {
-		line, err := reader.ReadString('\n')
+		line, err := reader.ReadString(delimiter)
 		if err != nil && err != io.EOF {
+			log.Printf("Warning: unable to read stdin: %v", err)
 			break
 		}
-		path := strings.TrimSpace(line)
-		if path != "" {
-			info, statErr := os.Lstat(path)
-			if statErr != nil {
-				fileChannel <- model.FileInfoRecord{Path: path, Err: statErr.Error()}
-			} else {
-				rec := lshound_files.ProcessPath(path, info, skipACL)
-				fileChannel <- rec
+		line = strings.TrimSuffix(line, string(delimiter))
+
+		switch stdinFormat {
+		case stdinNull:
+			// Names can hold any byte but NUL, they are taken as they are.
+			addStdinPath(collector, line, 0, false)
+		case stdinJSONL:
+			if strings.TrimSpace(line) == "" {
+				break
 			}
+			var entry stdinEntry
+			if jsonErr := json.Unmarshal([]byte(line), &entry); jsonErr != nil {
+				log.Printf("Warning: skipping stdin entry %q: %v", line, jsonErr)
+				break
+			}
+			addStdinPath(collector, entry.Path, entry.Depth, entry.Follow)
+		default:
+			addStdinPath(collector, strings.TrimSpace(line), 0, false)
 		}
 		if err == io.EOF {
 			break
 		}
 	}
+	collector.Close()
 	close(fileChannel)
 	wg.Done()
 }
 
+func addStdinPath(collector *lshound_files.Collector, path string, depth int, follow bool) {
+	if path == "" {
+		return
+	}
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	collector.Walk(path, depth, follow)
+}
+
+// stringList collects the values of a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func init() {
-	flag.StringVar(&startPath, "path", ".", "starting path")
+	flag.Var(&startPaths, "path", "starting path, can be repeated to walk several paths into one graph (default .)")
 	flag.BoolVar(&baseCollection, "basecollection", false, "output set to be in mapped to the OpenGraph format by default, use this switch to return the base collection")
-	flag.BoolVar(&skipACL, "skip-acl", false, "check for POSIX ACLs using getfacl, set this flag to skip")
-	flag.BoolVar(&followSymlink, "follow-symlink", false, "follow symlink when stat'ing files")
+	flag.BoolVar(&skipACL, "skip-acl", false, "POSIX ACLs are read from the system.posix_acl_access xattr, set this flag to skip")
+	flag.BoolVar(&followSymlink, "follow-symlink", false, "descend into the directories symlinks point to, each directory is walked once so symlink loops are cut")
 	flag.IntVar(&maxDepth, "max-depth", -1, "max recursive depth relative to start (-1 = unlimited)")
 	flag.BoolVar(&outputToStdOut, "stdout", false, "Output to standard out")
 	flag.StringVar(&outputName, "output", "output", "output file name")
+	flag.IntVar(&workers, "workers", runtime.NumCPU(), "number of paths to collect metadata for at the same time, output order does not depend on it")
+	flag.BoolVar(&perUser, "per-user", false, "evaluate each user's access under POSIX owner, group, other precedence and emit edges per user instead of per owner, group and other")
+	flag.BoolVar(&skipProcCaps, "skip-proc-caps", false, "capabilities held by running processes are read from /proc to find users that bypass file permissions, set this flag to skip")
+	flag.BoolVar(&pruneReach, "prune-unreachable", false, "drop access edges for principals that cannot search every parent directory, instead of marking them reachable=false")
+	flag.Var(&includes, "include", "collect paths matching this glob, or regular expression when prefixed with re:, even when excluded, can be repeated")
+	flag.Var(&excludes, "exclude", "leave out paths matching this glob, or regular expression when prefixed with re:, and everything below them, can be repeated")
+	flag.BoolVar(&oneFilesystem, "xdev", false, "stay on the filesystem of the starting path, mount points are collected but not descended into")
+	flag.StringVar(&stdinFormat, "stdin-format", stdinLines, "format of the paths read from stdin: lines, null for NUL-delimited as from find -print0, or jsonl for JSON objects with a path and optional depth and follow")
+	flag.StringVar(&passwdPath, "passwd", "/etc/passwd", "file to read the users from, such as a copy of /etc/passwd from another system")
+	flag.StringVar(&groupPath, "group", "/etc/group", "file to read the groups from, such as a copy of /etc/group from another system")
+	flag.StringVar(&offlineInput.LS, "from-ls", "", "build the graph offline from the output of ls -lan --full-time -R, instead of collecting")
+	flag.StringVar(&offlineInput.Stat, "from-stat", "", "build the graph offline from the output of QUOTING_STYLE=literal stat -c '%d %i %f %h %u %g %s %Y %N', instead of collecting")
+	flag.StringVar(&offlineInput.Getfacl, "from-getfacl", "", "add the ACLs from the output of getfacl -R to the offline listing, or build the graph from it alone")
+	flag.StringVar(&compression, "compress", writer.CompressNone, "compress the output: none, gzip or zip")
+	flag.IntVar(&chunkItems, "chunk-items", 0, "split the graph into files of at most this many nodes and edges (0 = no limit)")
+	flag.StringVar(&chunkSize, "chunk-size", "", "split the graph into files of at most this size before compression, such as 500M (empty = no limit)")
 }
 
 func main() {
@@ -71,30 +163,119 @@ func main() {
 	stat, _ := os.Stdin.Stat()
 	fileChannel = make(chan model.FileInfoRecord)
 
-	users, userErr := lshound_users.GetAllUsers()
+	// Offline, the listings describe another system, nothing is read from this one but the given files.
+	isOffline := offlineInput.LS != "" || offlineInput.Stat != "" || offlineInput.Getfacl != ""
+	if offlineInput.LS != "" && offlineInput.Stat != "" {
+		log.Fatal("only one of -from-ls and -from-stat can be given")
+	}
+
+	users, userErr := lshound_users.ReadUsers(passwdPath)
 	if userErr != nil {
 		log.Fatal(userErr)
 	}
 
-	groups, groupErr := lshound_groups.GetAllGroups()
+	if !skipProcCaps && !isOffline {
+		holders, capErr := capabilities.ProcessCapabilities()
+		if capErr != nil {
+			log.Printf("Warning: unable to read process capabilities: %v", capErr)
+		}
+		for i := range users {
+			users[i].Capabilities = holders[users[i].UID]
+		}
+	}
+
+	groups, groupErr := lshound_groups.ReadGroups(groupPath)
 	if groupErr != nil {
 		log.Fatal(groupErr)
 	}
 
-	if (stat.Mode() & os.ModeCharDevice) == 0 {
+	var index *identity.Index
+	var mountTable *mounts.Table
+	if isOffline {
+		index = identity.NewOfflineIndex(users, groups)
+	} else {
+		index = identity.NewIndex(users, groups)
+		var mountErr error
+		mountTable, mountErr = mounts.Load()
+		if mountErr != nil {
+			log.Printf("Warning: unable to read the mount table: %v", mountErr)
+		}
+	}
+	fileOpts = lshound_files.Options{
+		MaxDepth:      maxDepth,
+		FollowSymlink: followSymlink,
+		SkipACL:       skipACL,
+		Mounts:        mountTable,
+		Workers:       workers,
+		Identities:    index,
+		Filter:        lshound_files.Filter{OneFilesystem: oneFilesystem},
+	}
+	for _, include := range includes {
+		pattern, patternErr := lshound_files.ParsePattern(include)
+		if patternErr != nil {
+			log.Fatal(patternErr)
+		}
+		fileOpts.Filter.Include = append(fileOpts.Filter.Include, pattern)
+	}
+	for _, exclude := range excludes {
+		pattern, patternErr := lshound_files.ParsePattern(exclude)
+		if patternErr != nil {
+			log.Fatal(patternErr)
+		}
+		fileOpts.Filter.Exclude = append(fileOpts.Filter.Exclude, pattern)
+	}
+
+	switch stdinFormat {
+	case stdinLines, stdinNull, stdinJSONL:
+	default:
+		log.Fatalf("unknown stdin format %q, expected %s, %s or %s", stdinFormat, stdinLines, stdinNull, stdinJSONL)
+	}
+
+	limits := writer.ChunkLimits{Items: chunkItems}
+	if chunkSize != "" {
+		size, sizeErr := parseSize(chunkSize)
+		if sizeErr != nil {
+			log.Fatal(sizeErr)
+		}
+		limits.Bytes = size
+	}
+	if baseCollection && limits.Chunked() {
+		log.Fatal("the base collection can't be split into chunks, only the graph can")
+	}
+	var stdout io.Writer
+	if outputToStdOut {
+		stdout = os.Stdout
+	}
+	dest, destErr := writer.NewDestination(outputName, stdout, compression, limits.Chunked())
+	if destErr != nil {
+		log.Fatal(destErr)
+	}
+
+	if isOffline {
+		wg.Add(1)
+		go fromOffline(index)
+	} else if (stat.Mode() & os.ModeCharDevice) == 0 {
 		wg.Add(1)
 		go fromStdin()
 	} else {
-		if startPath == "" {
-			startPath = "."
+		if len(startPaths) == 0 {
+			startPaths = stringList{"."}
 		}
 
 		wg.Add(1)
-		go walk(startPath, maxDepth, followSymlink, skipACL, fileChannel)
+		go walk(startPaths, fileOpts, fileChannel)
+	}
+
+	graphOpts := writer.GraphOptions{
+		PruneUnreachable: pruneReach,
+		PerUser:          perUser,
+	}
+	if !isOffline {
+		graphOpts.Lookup = &fileOpts
 	}
 
 	wg.Add(1)
-	go runOutput(users, groups, fileChannel)
+	go runOutput(dest, limits, index, graphOpts, fileChannel)
 	wg.Wait()
 
 	if !outputToStdOut {
@@ -102,27 +283,58 @@ func main() {
 	}
 }
 
-func runOutput(users []model.User, groups []model.Group, fileChannel chan model.FileInfoRecord) {
-	var rec any
+func runOutput(dest *writer.Destination, limits writer.ChunkLimits, index *identity.Index, graphOpts writer.GraphOptions, fileChannel chan model.FileInfoRecord) {
+	defer wg.Done()
+
+	var err error
 	if baseCollection {
-		rec = writer.CreateBaseCollection(users, groups, fileChannel)
+		var out io.Writer
+		out, err = dest.Next()
+		if err == nil {
+			err = writer.WriteBaseCollection(out, index.Users(), index.Groups(), fileChannel)
+		}
+		err = errors.Join(err, dest.Close())
 	} else {
-		rec = writer.CreateGraph(users, groups, fileChannel)
+		err = writer.WriteGraph(dest, limits, index, fileChannel, graphOpts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "output error: ", err)
+		os.Exit(1)
 	}
-	outputJSON, _ := json.MarshalIndent(rec, "", "  ")
+}
 
-	if outputToStdOut {
-		fmt.Println(string(outputJSON))
-	} else {
-		f, _ := os.Create(fmt.Sprintf("%v.json", outputName))
-		defer f.Close()
-		f.Write(outputJSON)
+// parseSize reads a byte count with an optional K, M or G suffix, in powers of 1024.
+func parseSize(s string) (int64, error) {
+	multiplier := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+func fromOffline(index *identity.Index) {
+	if err := offline.Read(offlineInput, index, fileChannel); err != nil {
+		fmt.Fprintln(os.Stderr, "offline error: ", err)
+		wg.Done()
+		os.Exit(1)
 	}
 	wg.Done()
 }
 
-func walk(startPath string, maxDepth int, followSymlink bool, skipACL bool, fileChannel chan model.FileInfoRecord) {
-	if err := lshound_files.Walk(startPath, maxDepth, followSymlink, skipACL, fileChannel); err != nil {
+func walk(startPaths []string, opts lshound_files.Options, fileChannel chan model.FileInfoRecord) {
+	if err := lshound_files.Walk(startPaths, opts, fileChannel); err != nil {
 		fmt.Fprintln(os.Stderr, "walk error: ", err)
 		wg.Done()
 		os.Exit(1)
@@ -7,24 +7,91 @@ import (
 )
 
 type FileInfoRecord struct {
-	Path       string      `json:"path"`
-	Type       string      `json:"type"`
-	Mode       os.FileMode `json:"mode"`
-	ModeString string      `json:"mode_string"`
-	ModeOctal  string      `json:"mode_octal"`
-	UID        uint32      `json:"uid"`
-	GID        uint32      `json:"gid"`
-	User       string      `json:"user,omitempty"`
-	Group      string      `json:"group,omitempty"`
-	Size       int64       `json:"size"`
-	INode      uint64      `json:"inode"`
-	ModTime    time.Time   `json:"mod_time"`
-	IsSymlink  bool        `json:"is_symlink"`
-	LinkTarget string      `json:"link_target,omitempty"`
-	ACL        bool        `json:"acl"`
-	SetUID     bool        `json:"set_uid"`
-	SetGID     bool        `json:"set_gid"`
-	Err        string      `json:"err,omitempty"`
+	Path string `json:"path"`
+	// LogicalPath is the path a file was reached through when it lies below a followed symlink.
+	LogicalPath string      `json:"logical_path,omitempty"`
+	Type        string      `json:"type"`
+	Mode        os.FileMode `json:"mode"`
+	ModeString  string      `json:"mode_string"`
+	ModeOctal   string      `json:"mode_octal"`
+	UID         uint32      `json:"uid"`
+	GID         uint32      `json:"gid"`
+	User        string      `json:"user,omitempty"`
+	Group       string      `json:"group,omitempty"`
+	Size        int64       `json:"size"`
+	INode       uint64      `json:"inode"`
+	Dev         uint64      `json:"dev"`
+	NLink       uint64      `json:"nlink"`
+	ModTime     time.Time   `json:"mod_time"`
+	IsSymlink   bool        `json:"is_symlink"`
+	LinkTarget  string      `json:"link_target,omitempty"`
+	LinkChain   []LinkHop   `json:"link_chain,omitempty"`
+	ACL         bool        `json:"acl"`
+	ACLEntries  []ACLEntry  `json:"acl_entries,omitempty"`
+	DefaultACL  []ACLEntry  `json:"default_acl_entries,omitempty"`
+	SetUID      bool        `json:"set_uid"`
+	SetGID      bool        `json:"set_gid"`
+	Sticky      bool        `json:"sticky"`
+	// Capabilities are the permitted file capabilities, CapRootID is the root of the user namespace they
+	// apply in and is only set for namespaced capabilities.
+	Capabilities   []string `json:"capabilities,omitempty"`
+	CapInheritable []string `json:"cap_inheritable,omitempty"`
+	CapEffective   bool     `json:"cap_effective,omitempty"`
+	CapRootID      uint32   `json:"cap_root_id,omitempty"`
+	Attributes     []string `json:"attributes,omitempty"`
+	Immutable      bool     `json:"immutable,omitempty"`
+	AppendOnly     bool     `json:"append_only,omitempty"`
+	SELinuxLabel   string   `json:"selinux_label,omitempty"`
+	MountID        int      `json:"mount_id,omitempty"`
+	MountPoint     string   `json:"mount_point,omitempty"`
+	FSType         string   `json:"fs_type,omitempty"`
+	MountOptions   []string `json:"mount_options,omitempty"`
+	SMACKLabel     string   `json:"smack_label,omitempty"`
+	Ancestor       bool     `json:"ancestor,omitempty"`
+	// Placeholder records stand in for a symlink target that was not collected, only the path, type,
+	// device and inode are set.
+	Placeholder bool `json:"placeholder,omitempty"`
+	// DirDone marks a record that stands for no file but tells that every entry of the directory at Path has
+	// been sent, so what was kept for the directory can be let go. It is never written out.
+	DirDone bool   `json:"-"`
+	Err     string `json:"err,omitempty"`
+}
+
+// ACL entry tags, named after the tag types of acl(5).
+const (
+	ACLTagUserObj  = "user_obj"
+	ACLTagUser     = "user"
+	ACLTagGroupObj = "group_obj"
+	ACLTagGroup    = "group"
+	ACLTagMask     = "mask"
+	ACLTagOther    = "other"
+)
+
+// ACLEntry is a single POSIX ACL entry. ID is only meaningful for the user and group tags,
+// Perm holds the read, write and execute bits as 4, 2 and 1.
+type ACLEntry struct {
+	Tag  string `json:"tag"`
+	ID   uint32 `json:"id"`
+	Perm uint8  `json:"perm"`
+}
+
+// LinkHop is one step in resolving a symlink, the file a link in the chain points to. Missing is set when
+// nothing exists at the path, in which case only the path is known.
+type LinkHop struct {
+	Path    string `json:"path"`
+	Type    string `json:"type,omitempty"`
+	Dev     uint64 `json:"dev,omitempty"`
+	INode   uint64 `json:"inode,omitempty"`
+	Missing bool   `json:"missing,omitempty"`
+}
+
+// Mount is an entry of the mount table. Options holds both the mount options and the filesystem options.
+type Mount struct {
+	ID         int      `json:"id"`
+	MountPoint string   `json:"mount_point"`
+	FSType     string   `json:"fs_type"`
+	Source     string   `json:"source"`
+	Options    []string `json:"options"`
 }
 
 type User struct {
@@ -33,6 +100,8 @@ type User struct {
 	GID      uint32 `json:"gid"`
 	Home     string `json:"home"`
 	Shell    string `json:"shell"`
+	// Capabilities are the effective capabilities held by running processes of the user.
+	Capabilities []string `json:"capabilities,omitempty"`
 }
 
 type Group struct {
@@ -0,0 +1,123 @@
+// Package mounts reads the mount table so that files can be related to the filesystem they live on.
+package mounts
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	model "github.com/mykeelium/lshound/model"
+)
+
+// Table is the mount table, ordered from the longest mount point to the shortest.
+type Table struct {
+	mounts []model.Mount
+}
+
+// Load reads the mount table of the current process from /proc/self/mountinfo.
+func Load() (*Table, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+// Parse reads a mount table in the format of /proc/[pid]/mountinfo, see proc(5).
+func Parse(r io.Reader) (*Table, error) {
+	table := &Table{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		// The optional fields before the separator vary in number, so the fields after it are found from the separator.
+		fields := strings.Fields(line)
+		separator := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				separator = i
+				break
+			}
+		}
+		if separator < 0 || len(fields) < separator+4 {
+			return nil, fmt.Errorf("malformed mountinfo line: %q", line)
+		}
+
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("malformed mount ID in mountinfo line: %q", line)
+		}
+
+		options := strings.Split(fields[5], ",")
+		for _, option := range strings.Split(fields[separator+3], ",") {
+			// The superblock options repeat rw or ro, the mount's own setting is the one that counts.
+			if option != "rw" && option != "ro" {
+				options = append(options, option)
+			}
+		}
+
+		table.mounts = append(table.mounts, model.Mount{
+			ID:         id,
+			MountPoint: unescape(fields[4]),
+			FSType:     fields[separator+1],
+			Source:     unescape(fields[separator+2]),
+			Options:    options,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Mounts later in the table are stacked on top of earlier ones at the same mount point, so they are
+	// put first before ordering by mount point length.
+	for i, j := 0, len(table.mounts)-1; i < j; i, j = i+1, j-1 {
+		table.mounts[i], table.mounts[j] = table.mounts[j], table.mounts[i]
+	}
+	sort.SliceStable(table.mounts, func(i, j int) bool {
+		return len(table.mounts[i].MountPoint) > len(table.mounts[j].MountPoint)
+	})
+	return table, nil
+}
+
+// Lookup returns the mount a path lives on, or nil when the table is empty or missing.
+func (t *Table) Lookup(path string) *model.Mount {
+	if t == nil {
+		return nil
+	}
+	path = filepath.Clean(path)
+	for i := range t.mounts {
+		mountPoint := t.mounts[i].MountPoint
+		if path == mountPoint || mountPoint == "/" || strings.HasPrefix(path, mountPoint+"/") {
+			return &t.mounts[i]
+		}
+	}
+	return nil
+}
+
+// unescape decodes the octal escapes mountinfo uses for spaces, tabs, newlines and backslashes.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if value, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
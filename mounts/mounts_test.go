@@ -0,0 +1,86 @@
+package mounts
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const mountinfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw,errors=remount-ro
+23 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+24 22 0:22 / /mnt/my\040disk ro,relatime - vfat /dev/sdb\0401 rw,fmask=0022
+
+25 22 0:23 / /proc rw master:1 shared:2 - tmpfs none rw
+`
+
+func TestParse(t *testing.T) {
+	table, err := Parse(strings.NewReader(mountinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []int
+	for _, mount := range table.mounts {
+		ids = append(ids, mount.ID)
+	}
+	if want := []int{24, 25, 23, 22}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("mounts are ordered %v, want %v", ids, want)
+	}
+
+	disk := table.Lookup("/mnt/my disk/file")
+	if disk == nil || disk.ID != 24 {
+		t.Fatalf("Lookup(/mnt/my disk/file) = %+v, want mount 24", disk)
+	}
+	if disk.MountPoint != "/mnt/my disk" || disk.FSType != "vfat" || disk.Source != "/dev/sdb 1" {
+		t.Errorf("mount 24 = %+v", disk)
+	}
+	if want := []string{"ro", "relatime", "fmask=0022"}; !reflect.DeepEqual(disk.Options, want) {
+		t.Errorf("mount 24 options = %q, want %q", disk.Options, want)
+	}
+
+	root := table.Lookup("/etc/passwd")
+	if root == nil || root.ID != 22 {
+		t.Fatalf("Lookup(/etc/passwd) = %+v, want mount 22", root)
+	}
+	if want := []string{"rw", "relatime", "errors=remount-ro"}; !reflect.DeepEqual(root.Options, want) {
+		t.Errorf("mount 22 options = %q, want %q", root.Options, want)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	table, err := Parse(strings.NewReader(mountinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]int{
+		"/":            22,
+		"/proc":        25,
+		"/proc/self/":  25,
+		"/procfs":      22,
+		"/mnt/my disk": 24,
+		"/mnt/my":      22,
+	}
+	for path, want := range tests {
+		if got := table.Lookup(path); got == nil || got.ID != want {
+			t.Errorf("Lookup(%q) = %+v, want mount %d", path, got, want)
+		}
+	}
+
+	var missing *Table
+	if got := missing.Lookup("/"); got != nil {
+		t.Errorf("Lookup on a missing table = %+v, want nil", got)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, line := range []string{
+		"22 1 8:1 / / rw,relatime shared:1 ext4 /dev/sda1 rw",
+		"22 1 8:1 / / rw - ext4 /dev/sda1",
+		"x 1 8:1 / / rw - ext4 /dev/sda1 rw",
+	} {
+		if _, err := Parse(strings.NewReader(line + "\n")); err == nil {
+			t.Errorf("Parse(%q) did not fail", line)
+		}
+	}
+}
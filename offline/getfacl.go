@@ -0,0 +1,287 @@
+package offline
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mykeelium/lshound/files"
+	"github.com/mykeelium/lshound/identity"
+	model "github.com/mykeelium/lshound/model"
+)
+
+// aclListing holds the ACLs read from getfacl output by path, in the order they were listed.
+type aclListing struct {
+	acls  map[string]*aclRecord
+	order []string
+}
+
+// aclRecord is what getfacl prints for a file. Owner, group and the IDs in the entries are names, or
+// numbers when getfacl was run with -n.
+type aclRecord struct {
+	path     string
+	owner    string
+	group    string
+	flags    string
+	access   []aclLine
+	defaults []aclLine
+	// used is set once the ACL was added to a record of the listing.
+	used bool
+}
+
+type aclLine struct {
+	tag  string
+	name string
+	perm uint8
+}
+
+// parseGetfacl reads the output of getfacl -R. getfacl drops the leading / of absolute paths unless run
+// with -p, paths without it are taken to be absolute.
+func parseGetfacl(r io.Reader) (*aclListing, error) {
+	listing := &aclListing{acls: map[string]*aclRecord{}}
+	scanner := newScanner(r)
+	var current *aclRecord
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header, ok := strings.CutPrefix(line, "# "); ok {
+			key, value, _ := strings.Cut(header, ": ")
+			if key == "file" {
+				path := unescape(value)
+				if !strings.HasPrefix(path, "/") {
+					path = "/" + path
+				}
+				current = &aclRecord{path: path}
+				if _, ok := listing.acls[path]; !ok {
+					listing.order = append(listing.order, path)
+				}
+				listing.acls[path] = current
+				continue
+			}
+			if current == nil {
+				continue
+			}
+			switch key {
+			case "owner":
+				current.owner = unescape(value)
+			case "group":
+				current.group = unescape(value)
+			case "flags":
+				current.flags = value
+			}
+			continue
+		}
+
+		// Entries may be followed by a comment with the permissions the mask leaves of them.
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if line == "" || current == nil {
+			continue
+		}
+		entry, isDefault, err := parseACLLine(line)
+		if err != nil {
+			log.Printf("Warning: skipping getfacl line %q of %s: %v", line, current.path, err)
+			continue
+		}
+		if isDefault {
+			current.defaults = append(current.defaults, entry)
+		} else {
+			current.access = append(current.access, entry)
+		}
+	}
+	return listing, scanner.Err()
+}
+
+// parseACLLine parses an entry such as user:alice:r-x or default:mask::rwx.
+func parseACLLine(line string) (aclLine, bool, error) {
+	line, isDefault := strings.CutPrefix(line, "default:")
+	parts := strings.Split(line, ":")
+	if len(parts) != 3 || len(parts[2]) != 3 {
+		return aclLine{}, false, fmt.Errorf("invalid entry")
+	}
+
+	entry := aclLine{name: unescape(parts[1])}
+	switch {
+	case parts[0] == "user" && entry.name == "":
+		entry.tag = model.ACLTagUserObj
+	case parts[0] == "user":
+		entry.tag = model.ACLTagUser
+	case parts[0] == "group" && entry.name == "":
+		entry.tag = model.ACLTagGroupObj
+	case parts[0] == "group":
+		entry.tag = model.ACLTagGroup
+	case parts[0] == "mask":
+		entry.tag = model.ACLTagMask
+	case parts[0] == "other":
+		entry.tag = model.ACLTagOther
+	default:
+		return aclLine{}, false, fmt.Errorf("unknown tag %q", parts[0])
+	}
+
+	for i, c := range parts[2] {
+		switch {
+		case c == rune("rwx"[i]):
+			entry.perm |= 4 >> i
+		case c != '-':
+			return aclLine{}, false, fmt.Errorf("invalid permissions %q", parts[2])
+		}
+	}
+	return entry, isDefault, nil
+}
+
+// apply adds the ACL of the record's path, if there is one, to the record.
+func (l *aclListing) apply(rec *model.FileInfoRecord, index *identity.Index) {
+	if l == nil {
+		return
+	}
+	acl, ok := l.acls[rec.Path]
+	if !ok {
+		return
+	}
+	acl.used = true
+	acl.setEntries(rec, index)
+}
+
+// unused returns the ACLs of paths that no record was sent for.
+func (l *aclListing) unused() []*aclRecord {
+	if l == nil {
+		return nil
+	}
+	var unused []*aclRecord
+	for _, path := range l.order {
+		if acl := l.acls[path]; !acl.used {
+			unused = append(unused, acl)
+		}
+	}
+	return unused
+}
+
+// setEntries resolves the entries to IDs and sets them on the record. Names that can't be resolved are
+// reported in the record's error.
+func (a *aclRecord) setEntries(rec *model.FileInfoRecord, index *identity.Index) {
+	var errs []string
+	resolve := func(lines []aclLine) []model.ACLEntry {
+		entries := make([]model.ACLEntry, 0, len(lines))
+		for _, line := range lines {
+			entry := model.ACLEntry{Tag: line.tag, Perm: line.perm}
+			if line.tag == model.ACLTagUser || line.tag == model.ACLTagGroup {
+				id, ok := resolveID(line.name, line.tag == model.ACLTagUser, index)
+				if !ok {
+					errs = append(errs, fmt.Sprintf("unknown %s %q in ACL", line.tag, line.name))
+					continue
+				}
+				entry.ID = id
+			}
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	rec.ACLEntries = resolve(a.access)
+	if len(a.defaults) > 0 {
+		rec.DefaultACL = resolve(a.defaults)
+	}
+	rec.ACL = len(rec.DefaultACL) > 0
+	for _, entry := range rec.ACLEntries {
+		if entry.Tag != model.ACLTagUserObj && entry.Tag != model.ACLTagGroupObj && entry.Tag != model.ACLTagOther {
+			rec.ACL = true
+		}
+	}
+	if len(errs) > 0 {
+		if rec.Err != "" {
+			errs = append([]string{rec.Err}, errs...)
+		}
+		rec.Err = strings.Join(errs, "; ")
+	}
+}
+
+// record describes a file from its ACL alone. getfacl does not tell what type of file it is, files with
+// a default ACL are directories and the rest are taken to be regular files.
+func (a *aclRecord) record(index *identity.Index) model.FileInfoRecord {
+	rec := model.FileInfoRecord{Path: a.path}
+	a.setEntries(&rec, index)
+
+	// With an ACL, the group bits of the mode are those of the mask.
+	var mode os.FileMode
+	var groupPerm, maskPerm uint8
+	hasMask := false
+	for _, entry := range a.access {
+		switch entry.tag {
+		case model.ACLTagUserObj:
+			mode |= os.FileMode(entry.perm) << 6
+		case model.ACLTagGroupObj:
+			groupPerm = entry.perm
+		case model.ACLTagMask:
+			maskPerm, hasMask = entry.perm, true
+		case model.ACLTagOther:
+			mode |= os.FileMode(entry.perm)
+		}
+	}
+	if hasMask {
+		groupPerm = maskPerm
+	}
+	mode |= os.FileMode(groupPerm) << 3
+	if len(a.defaults) > 0 {
+		mode |= os.ModeDir
+	}
+	// The flags are written as sst, with - for the ones that are not set.
+	if len(a.flags) == 3 {
+		special := []os.FileMode{os.ModeSetuid, os.ModeSetgid, os.ModeSticky}
+		for i := range special {
+			if a.flags[i] != '-' {
+				mode |= special[i]
+			}
+		}
+	}
+	files.SetMode(&rec, mode)
+
+	if uid, ok := resolveID(a.owner, true, index); ok {
+		rec.UID = uid
+	}
+	if gid, ok := resolveID(a.group, false, index); ok {
+		rec.GID = gid
+	}
+	rec.User = index.UserName(rec.UID)
+	rec.Group = index.GroupName(rec.GID)
+	return rec
+}
+
+// resolveID returns the ID of a user or group name, or of a number as printed by getfacl -n.
+func resolveID(name string, isUser bool, index *identity.Index) (uint32, bool) {
+	lookup := index.GroupID
+	if isUser {
+		lookup = index.UserID
+	}
+	if id, ok := lookup(name); ok {
+		return id, true
+	}
+	id, err := strconv.ParseUint(name, 10, 32)
+	return uint32(id), err == nil
+}
+
+// unescape decodes the backslash escapes getfacl writes for special characters in names, \ followed by
+// three octal digits, and \\ for a backslash.
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == '\\' {
+			b.WriteByte('\\')
+			i++
+			continue
+		}
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
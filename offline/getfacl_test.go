@@ -0,0 +1,164 @@
+package offline
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	model "github.com/mykeelium/lshound/model"
+)
+
+const getfaclListing = `# file: srv/a\040b
+# owner: alice
+# group: staff
+# flags: -s-
+user::rwx
+user:bob:r-x			#effective:r--
+group::rwx			#effective:r--
+mask::r--
+other::---
+default:user::rwx
+default:group::r-x
+default:other::---
+
+# file: /abs/back\\slash
+# owner: 1000
+# group: 100
+user::rw-
+user:mallory:rw-
+group::r--
+other::r--
+bogus::rwx
+`
+
+func TestParseGetfacl(t *testing.T) {
+	listing, err := parseGetfacl(strings.NewReader(getfaclListing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/srv/a b", "/abs/back\\slash"}; !reflect.DeepEqual(listing.order, want) {
+		t.Fatalf("paths = %q, want %q", listing.order, want)
+	}
+
+	acl := listing.acls["/srv/a b"]
+	if acl.owner != "alice" || acl.group != "staff" || acl.flags != "-s-" {
+		t.Errorf("header = %s:%s %s", acl.owner, acl.group, acl.flags)
+	}
+	wantAccess := []aclLine{
+		{tag: model.ACLTagUserObj, perm: 0o7},
+		{tag: model.ACLTagUser, name: "bob", perm: 0o5},
+		{tag: model.ACLTagGroupObj, perm: 0o7},
+		{tag: model.ACLTagMask, perm: 0o4},
+		{tag: model.ACLTagOther, perm: 0},
+	}
+	if !reflect.DeepEqual(acl.access, wantAccess) {
+		t.Errorf("access = %+v, want %+v", acl.access, wantAccess)
+	}
+	if len(acl.defaults) != 3 {
+		t.Errorf("defaults = %+v, want 3 entries", acl.defaults)
+	}
+	if got := len(listing.acls["/abs/back\\slash"].access); got != 4 {
+		t.Errorf("/abs/back\\slash has %d entries, want 4 with the invalid one skipped", got)
+	}
+}
+
+func TestApplyGetfacl(t *testing.T) {
+	listing, err := parseGetfacl(strings.NewReader(getfaclListing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := testIndex()
+
+	rec := model.FileInfoRecord{Path: "/abs/back\\slash", Err: "earlier"}
+	listing.apply(&rec, index)
+	wantEntries := []model.ACLEntry{
+		{Tag: model.ACLTagUserObj, Perm: 0o6},
+		{Tag: model.ACLTagGroupObj, Perm: 0o4},
+		{Tag: model.ACLTagOther, Perm: 0o4},
+	}
+	if !reflect.DeepEqual(rec.ACLEntries, wantEntries) {
+		t.Errorf("entries = %+v, want %+v", rec.ACLEntries, wantEntries)
+	}
+	if rec.ACL {
+		t.Error("an ACL of only the base entries is reported as an ACL")
+	}
+	if want := `earlier; unknown user "mallory" in ACL`; rec.Err != want {
+		t.Errorf("error = %q, want %q", rec.Err, want)
+	}
+
+	// The ACL that no record was sent for describes a directory on its own.
+	unused := listing.unused()
+	if len(unused) != 1 || unused[0].path != "/srv/a b" {
+		t.Fatalf("unused = %+v", unused)
+	}
+	dir := unused[0].record(index)
+	if dir.Mode != os.ModeDir|os.ModeSetgid|0o740 || dir.Type != "dir" || !dir.ACL {
+		t.Errorf("record = %+v", dir)
+	}
+	if dir.UID != 1000 || dir.GID != 100 || dir.User != "alice" || dir.Group != "staff" {
+		t.Errorf("record is owned by %d:%d, want 1000:100", dir.UID, dir.GID)
+	}
+	if want := (model.ACLEntry{Tag: model.ACLTagUser, ID: 1001, Perm: 0o5}); !reflect.DeepEqual(dir.ACLEntries[1], want) {
+		t.Errorf("named entry = %+v, want %+v", dir.ACLEntries[1], want)
+	}
+}
+
+func TestParseACLLine(t *testing.T) {
+	tests := []struct {
+		in          string
+		want        aclLine
+		wantDefault bool
+		wantErr     bool
+	}{
+		{in: "user::rwx", want: aclLine{tag: model.ACLTagUserObj, perm: 0o7}},
+		{in: "user:1000:r--", want: aclLine{tag: model.ACLTagUser, name: "1000", perm: 0o4}},
+		{in: "group::-w-", want: aclLine{tag: model.ACLTagGroupObj, perm: 0o2}},
+		{in: "group:wheel:--x", want: aclLine{tag: model.ACLTagGroup, name: "wheel", perm: 0o1}},
+		{in: "default:mask::rwx", want: aclLine{tag: model.ACLTagMask, perm: 0o7}, wantDefault: true},
+		{in: "other::---", want: aclLine{tag: model.ACLTagOther}},
+		{in: `user:a\040b:r--`, want: aclLine{tag: model.ACLTagUser, name: "a b", perm: 0o4}},
+		{in: "user:bob", wantErr: true},
+		{in: "user::rw", wantErr: true},
+		{in: "user::rwz", wantErr: true},
+		{in: "user::xwr", wantErr: true},
+		{in: "owner::rwx", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, isDefault, err := parseACLLine(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseACLLine(%q) = %+v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || isDefault != tt.wantDefault {
+				t.Errorf("parseACLLine(%q) = %+v, %t, want %+v, %t", tt.in, got, isDefault, tt.want, tt.wantDefault)
+			}
+		})
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := map[string]string{
+		"plain":           "plain",
+		`a\040b`:          "a b",
+		`tab\011here`:     "tab\there",
+		`back\\slash`:     `back\slash`,
+		`\\040`:           `\040`,
+		`\101\102`:        "AB",
+		`not\08octal`:     `not\08octal`,
+		`trailing\04`:     `trailing\04`,
+		`new\012line\\\\`: "new\nline\\\\",
+	}
+	for in, want := range tests {
+		if got := unescape(in); got != want {
+			t.Errorf("unescape(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package offline
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mykeelium/lshound/files"
+	"github.com/mykeelium/lshound/identity"
+	model "github.com/mykeelium/lshound/model"
+)
+
+// lsTimeLayout is the layout of the time ls prints with --full-time.
+const lsTimeLayout = "2006-01-02 15:04:05.999999999 -0700"
+
+// parseLS reads the output of ls -lan --full-time -R. Each listing starts with the directory and a colon,
+// entries are named relative to it. A leading inode column, as printed with -i, is used when present.
+func parseLS(r io.Reader, index *identity.Index, emit func(model.FileInfoRecord)) error {
+	scanner := newScanner(r)
+	dir := ""
+	// A directory is listed both in its parent and as . in its own listing, it is sent once.
+	seenDirs := map[string]bool{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "total ") {
+			continue
+		}
+
+		rec, name, err := parseLSLine(line, index)
+		if err != nil {
+			if strings.HasSuffix(line, ":") {
+				dir = filepath.Clean(strings.TrimSuffix(line, ":"))
+				continue
+			}
+			log.Printf("Warning: skipping ls line %q: %v", line, err)
+			continue
+		}
+
+		switch name {
+		case "..":
+			continue
+		case ".":
+			rec.Path = dir
+		default:
+			rec.Path = filepath.Join(dir, name)
+		}
+		if rec.Type == "dir" {
+			if seenDirs[rec.Path] {
+				continue
+			}
+			seenDirs[rec.Path] = true
+		}
+		emit(rec)
+	}
+	return scanner.Err()
+}
+
+// parseLSLine parses a single entry and returns the record, without its path, and the name of the entry.
+func parseLSLine(line string, index *identity.Index) (model.FileInfoRecord, string, error) {
+	var rec model.FileInfoRecord
+
+	field, pos := nextField(line, 0)
+	if inode, err := strconv.ParseUint(field, 10, 64); err == nil {
+		rec.INode = inode
+		field, pos = nextField(line, pos)
+	}
+	mode, acl, err := parseModeString(field)
+	if err != nil {
+		return rec, "", err
+	}
+	files.SetMode(&rec, mode)
+	rec.ACL = acl
+
+	var fields [3]string
+	for i := range fields {
+		fields[i], pos = nextField(line, pos)
+	}
+	if rec.NLink, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return rec, "", fmt.Errorf("invalid link count %q", fields[0])
+	}
+	uid, err := parseUint32(fields[1])
+	if err != nil {
+		return rec, "", fmt.Errorf("invalid UID %q", fields[1])
+	}
+	gid, err := parseUint32(fields[2])
+	if err != nil {
+		return rec, "", fmt.Errorf("invalid GID %q", fields[2])
+	}
+	setOwner(&rec, uid, gid, index)
+
+	// Devices have their major and minor number in place of a size.
+	size, pos := nextField(line, pos)
+	if strings.HasSuffix(size, ",") {
+		_, pos = nextField(line, pos)
+	} else if rec.Size, err = strconv.ParseInt(size, 10, 64); err != nil {
+		return rec, "", fmt.Errorf("invalid size %q", size)
+	}
+
+	var date [3]string
+	for i := range date {
+		date[i], pos = nextField(line, pos)
+	}
+	// Times that are not in the --full-time format are left out, the rest of the entry is still good.
+	if modTime, err := time.Parse(lsTimeLayout, strings.Join(date[:], " ")); err == nil {
+		rec.ModTime = modTime
+	}
+
+	if pos+1 > len(line) {
+		return rec, "", fmt.Errorf("missing name")
+	}
+	name := line[pos+1:]
+	if rec.IsSymlink {
+		name, rec.LinkTarget = splitLink(name)
+	}
+	return rec, name, nil
+}
+
+// parseModeString parses the mode column of ls, such as drwxr-sr-x or -rw-r--r--+. It also tells whether
+// the file has an ACL, which ls marks with a trailing +.
+func parseModeString(s string) (os.FileMode, bool, error) {
+	if len(s) < 10 {
+		return 0, false, fmt.Errorf("invalid mode %q", s)
+	}
+
+	var mode os.FileMode
+	switch s[0] {
+	case '-':
+	case 'd':
+		mode |= os.ModeDir
+	case 'l':
+		mode |= os.ModeSymlink
+	case 'c':
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case 'b':
+		mode |= os.ModeDevice
+	case 'p':
+		mode |= os.ModeNamedPipe
+	case 's':
+		mode |= os.ModeSocket
+	default:
+		return 0, false, fmt.Errorf("invalid mode %q", s)
+	}
+
+	// The execute position also holds the setuid, setgid and sticky bits, lowercase when execute is set too.
+	special := []os.FileMode{os.ModeSetuid, os.ModeSetgid, os.ModeSticky}
+	for i, c := range s[1:10] {
+		bit := os.FileMode(1) << (8 - i)
+		switch {
+		case c == rune("rwx"[i%3]):
+			mode |= bit
+		case i%3 == 2 && (c == 's' || c == 't'):
+			mode |= bit | special[i/3]
+		case i%3 == 2 && (c == 'S' || c == 'T'):
+			mode |= special[i/3]
+		case c != '-':
+			return 0, false, fmt.Errorf("invalid mode %q", s)
+		}
+	}
+	return mode, len(s) > 10 && s[10] == '+', nil
+}
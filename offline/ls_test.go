@@ -0,0 +1,164 @@
+package offline
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mykeelium/lshound/identity"
+	model "github.com/mykeelium/lshound/model"
+)
+
+func testIndex() *identity.Index {
+	return identity.NewOfflineIndex(
+		[]model.User{
+			{Username: "root", UID: 0, GID: 0},
+			{Username: "alice", UID: 1000, GID: 100},
+			{Username: "bob", UID: 1001, GID: 100},
+		},
+		[]model.Group{
+			{Name: "root", GID: 0},
+			{Name: "staff", GID: 100, Members: []string{"alice"}},
+		},
+	)
+}
+
+func collect(t *testing.T, parse func(emit func(model.FileInfoRecord)) error) map[string]model.FileInfoRecord {
+	t.Helper()
+	records := map[string]model.FileInfoRecord{}
+	err := parse(func(rec model.FileInfoRecord) {
+		if _, ok := records[rec.Path]; ok {
+			t.Errorf("%s sent twice", rec.Path)
+		}
+		records[rec.Path] = rec
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestParseLS(t *testing.T) {
+	listing := `/srv:
+total 12
+drwxr-xr-x  3 0 0 4096 2024-01-02 03:04:05.123456789 +0000 .
+drwxr-xr-x 20 0 0 4096 2024-01-02 03:04:05.000000000 +0000 ..
+crw-rw----  1 0 5 4, 64 2024-01-02 03:04:05.000000000 +0000 tty
+-rwsr-x---+ 1 1000 100 10 2024-01-02 03:04:05.000000000 +0100 with  space
+drwxrwx--T  2 0 100 4096 2024-01-02 03:04:05.000000000 +0000 tmp
+lrwxrwxrwx  1 0 0 7 2024-01-02 03:04:05.000000000 +0000 link -> ../target
+-rw-r--r--  1 2000 2000 0 Jan  2 03:04 short-time
+garbage
+
+/srv/tmp:
+total 0
+drwxrwx--T 2 0 100 4096 2024-01-02 03:04:05.000000000 +0000 .
+drwxr-xr-x 3 0 0 4096 2024-01-02 03:04:05.123456789 +0000 ..
+`
+	records := collect(t, func(emit func(model.FileInfoRecord)) error {
+		return parseLS(strings.NewReader(listing), testIndex(), emit)
+	})
+	if len(records) != 6 {
+		t.Fatalf("got %d records, want 6: %v", len(records), records)
+	}
+
+	srv := records["/srv"]
+	if srv.Type != "dir" || srv.Mode != os.ModeDir|0o755 || srv.NLink != 3 || srv.User != "root" {
+		t.Errorf("/srv = %+v", srv)
+	}
+	if want := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC); !srv.ModTime.Equal(want) {
+		t.Errorf("/srv modification time = %v, want %v", srv.ModTime, want)
+	}
+
+	tty := records["/srv/tty"]
+	if tty.Mode != os.ModeDevice|os.ModeCharDevice|0o660 || tty.GID != 5 || tty.Size != 0 {
+		t.Errorf("/srv/tty = %+v", tty)
+	}
+
+	file := records["/srv/with  space"]
+	if file.Mode != os.ModeSetuid|0o750 || !file.SetUID || !file.ACL || file.Size != 10 {
+		t.Errorf("/srv/with  space = %+v", file)
+	}
+	if file.User != "alice" || file.Group != "staff" {
+		t.Errorf("/srv/with  space is owned by %s:%s, want alice:staff", file.User, file.Group)
+	}
+
+	tmp := records["/srv/tmp"]
+	if tmp.Mode != os.ModeDir|os.ModeSticky|0o770 || !tmp.Sticky {
+		t.Errorf("/srv/tmp = %+v", tmp)
+	}
+
+	link := records["/srv/link"]
+	if !link.IsSymlink || link.Type != "syslink" || link.LinkTarget != "../target" {
+		t.Errorf("/srv/link = %+v", link)
+	}
+
+	short := records["/srv/short-time"]
+	if !short.ModTime.IsZero() || short.UID != 2000 || short.User != "" {
+		t.Errorf("/srv/short-time = %+v", short)
+	}
+}
+
+func TestParseLSInodes(t *testing.T) {
+	listing := `/data:
+total 4
+ 131 drwxr-xr-x 2 0 0 4096 2024-01-02 03:04:05.000000000 +0000 .
+   2 drwxr-xr-x 3 0 0 4096 2024-01-02 03:04:05.000000000 +0000 ..
+4242 -rw-r--r-- 2 1001 100 5 2024-01-02 03:04:05.000000000 +0000 hardlinked
+`
+	records := collect(t, func(emit func(model.FileInfoRecord)) error {
+		return parseLS(strings.NewReader(listing), testIndex(), emit)
+	})
+	if got := records["/data"].INode; got != 131 {
+		t.Errorf("/data inode = %d, want 131", got)
+	}
+	file := records["/data/hardlinked"]
+	if file.INode != 4242 || file.NLink != 2 || file.User != "bob" {
+		t.Errorf("/data/hardlinked = %+v", file)
+	}
+}
+
+func TestParseModeString(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    os.FileMode
+		wantACL bool
+		wantErr bool
+	}{
+		{in: "-rw-r--r--", want: 0o644},
+		{in: "drwxr-sr-x", want: os.ModeDir | os.ModeSetgid | 0o755},
+		{in: "-rwsr-xr-x+", want: os.ModeSetuid | 0o755, wantACL: true},
+		{in: "-rwSr--r--", want: os.ModeSetuid | 0o644},
+		{in: "-rw-r-Sr--", want: os.ModeSetgid | 0o644},
+		{in: "drwxrwxrwt", want: os.ModeDir | os.ModeSticky | 0o777},
+		{in: "drwxrwx--T", want: os.ModeDir | os.ModeSticky | 0o770},
+		{in: "lrwxrwxrwx", want: os.ModeSymlink | 0o777},
+		{in: "brw-rw----", want: os.ModeDevice | 0o660},
+		{in: "crw--w----", want: os.ModeDevice | os.ModeCharDevice | 0o620},
+		{in: "prw-------", want: os.ModeNamedPipe | 0o600},
+		{in: "srwxrwxrwx", want: os.ModeSocket | 0o777},
+		{in: "-rw-r--r--.", want: 0o644},
+		{in: "xrw-r--r--", wantErr: true},
+		{in: "-rwxq-----", wantErr: true},
+		{in: "-rws-", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			mode, hasACL, err := parseModeString(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseModeString(%q) = %v, want an error", tt.in, mode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode != tt.want || hasACL != tt.wantACL {
+				t.Errorf("parseModeString(%q) = %v, %t, want %v, %t", tt.in, mode, hasACL, tt.want, tt.wantACL)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Package offline builds file records from text listings captured on another system, for when lshound
+// can't be run there itself.
+package offline
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mykeelium/lshound/identity"
+	model "github.com/mykeelium/lshound/model"
+)
+
+// Sources are the files holding the listings. LS and Stat describe the files themselves, Getfacl adds the
+// ACLs of the files they describe, or describes the files on its own when neither is given.
+type Sources struct {
+	// LS holds the output of ls -lan --full-time -R.
+	LS string
+	// Stat holds the output of QUOTING_STYLE=literal stat -c '%d %i %f %h %u %g %s %Y %N'.
+	Stat string
+	// Getfacl holds the output of getfacl -R.
+	Getfacl string
+}
+
+// Read sends a record for every file in the listings, then closes out. Lines that can't be understood are
+// skipped with a warning.
+func Read(sources Sources, index *identity.Index, out chan<- model.FileInfoRecord) error {
+	defer close(out)
+
+	var acls *aclListing
+	if sources.Getfacl != "" {
+		err := readFile(sources.Getfacl, func(r io.Reader) error {
+			var err error
+			acls, err = parseGetfacl(r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	emit := func(rec model.FileInfoRecord) {
+		acls.apply(&rec, index)
+		out <- rec
+	}
+	if sources.LS != "" {
+		if err := readFile(sources.LS, func(r io.Reader) error { return parseLS(r, index, emit) }); err != nil {
+			return err
+		}
+	}
+	if sources.Stat != "" {
+		if err := readFile(sources.Stat, func(r io.Reader) error { return parseStat(r, index, emit) }); err != nil {
+			return err
+		}
+	}
+
+	// Files that only getfacl knows about are described from their ACL.
+	for _, acl := range acls.unused() {
+		out <- acl.record(index)
+	}
+	return nil
+}
+
+func readFile(path string, parse func(io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return parse(f)
+}
+
+// newScanner returns a line scanner that allows for long paths.
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return scanner
+}
+
+// nextField returns the space separated field of line that starts at or after pos, and the position
+// right after it.
+func nextField(line string, pos int) (string, int) {
+	for pos < len(line) && line[pos] == ' ' {
+		pos++
+	}
+	start := pos
+	for pos < len(line) && line[pos] != ' ' {
+		pos++
+	}
+	return line[start:pos], pos
+}
+
+// splitLink splits the name of a symlink from its target, as both ls and stat write them.
+func splitLink(name string) (string, string) {
+	if link, target, ok := strings.Cut(name, " -> "); ok {
+		return link, target
+	}
+	return name, ""
+}
+
+// setOwner sets the IDs of a record and the names they have in the index.
+func setOwner(rec *model.FileInfoRecord, uid uint32, gid uint32, index *identity.Index) {
+	rec.UID = uid
+	rec.GID = gid
+	rec.User = index.UserName(uid)
+	rec.Group = index.GroupName(gid)
+}
+
+func parseUint32(s string) (uint32, error) {
+	n, err := strconv.ParseUint(s, 10, 32)
+	return uint32(n), err
+}
+
+// unixMode converts the st_mode bits of stat to a FileMode.
+func unixMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0o777)
+	switch m & 0o170000 {
+	case 0o040000:
+		mode |= os.ModeDir
+	case 0o120000:
+		mode |= os.ModeSymlink
+	case 0o020000:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case 0o060000:
+		mode |= os.ModeDevice
+	case 0o010000:
+		mode |= os.ModeNamedPipe
+	case 0o140000:
+		mode |= os.ModeSocket
+	}
+	if m&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
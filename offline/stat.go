@@ -0,0 +1,85 @@
+package offline
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/mykeelium/lshound/files"
+	"github.com/mykeelium/lshound/identity"
+	model "github.com/mykeelium/lshound/model"
+)
+
+// parseStat reads the output of QUOTING_STYLE=literal stat -c '%d %i %f %h %u %g %s %Y %N', that is the
+// device, inode, raw mode in hex, link count, UID, GID, size, modification time and the name, which is
+// followed by -> and the target for symlinks.
+func parseStat(r io.Reader, index *identity.Index, emit func(model.FileInfoRecord)) error {
+	scanner := newScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		rec, err := parseStatLine(line, index)
+		if err != nil {
+			log.Printf("Warning: skipping stat line %q: %v", line, err)
+			continue
+		}
+		emit(rec)
+	}
+	return scanner.Err()
+}
+
+func parseStatLine(line string, index *identity.Index) (model.FileInfoRecord, error) {
+	var rec model.FileInfoRecord
+
+	var fields [8]string
+	pos := 0
+	for i := range fields {
+		fields[i], pos = nextField(line, pos)
+	}
+	if pos+1 > len(line) {
+		return rec, fmt.Errorf("missing name")
+	}
+
+	var err error
+	if rec.Dev, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return rec, fmt.Errorf("invalid device %q", fields[0])
+	}
+	if rec.INode, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return rec, fmt.Errorf("invalid inode %q", fields[1])
+	}
+	mode, err := strconv.ParseUint(fields[2], 16, 32)
+	if err != nil {
+		return rec, fmt.Errorf("invalid mode %q", fields[2])
+	}
+	files.SetMode(&rec, unixMode(uint32(mode)))
+	if rec.NLink, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+		return rec, fmt.Errorf("invalid link count %q", fields[3])
+	}
+	uid, err := parseUint32(fields[4])
+	if err != nil {
+		return rec, fmt.Errorf("invalid UID %q", fields[4])
+	}
+	gid, err := parseUint32(fields[5])
+	if err != nil {
+		return rec, fmt.Errorf("invalid GID %q", fields[5])
+	}
+	setOwner(&rec, uid, gid, index)
+	if rec.Size, err = strconv.ParseInt(fields[6], 10, 64); err != nil {
+		return rec, fmt.Errorf("invalid size %q", fields[6])
+	}
+	modTime, err := strconv.ParseInt(fields[7], 10, 64)
+	if err != nil {
+		return rec, fmt.Errorf("invalid modification time %q", fields[7])
+	}
+	rec.ModTime = time.Unix(modTime, 0).UTC()
+
+	rec.Path = line[pos+1:]
+	if rec.IsSymlink {
+		rec.Path, rec.LinkTarget = splitLink(rec.Path)
+	}
+	return rec, nil
+}
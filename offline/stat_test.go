@@ -0,0 +1,75 @@
+package offline
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	model "github.com/mykeelium/lshound/model"
+)
+
+func TestParseStat(t *testing.T) {
+	listing := `2049 131 81a4 1 1000 100 42 1704164645 /etc/with  space
+2049 132 a1ff 1 0 0 7 1704164645 /etc/link -> ../usr/target
+2049 133 43fd 2 0 100 4096 1704164645 /etc/dir
+2049 134 21b0 1 0 5 0 1704164645 /dev/tty0
+2049 135 89ed 1 0 0 10 1704164645 /usr/bin/sudo
+2049 x 81a4 1 0 0 0 1704164645 /etc/bad
+2049 136 81a4 1 0 0 0 1704164645
+`
+	records := collect(t, func(emit func(model.FileInfoRecord)) error {
+		return parseStat(strings.NewReader(listing), testIndex(), emit)
+	})
+	if len(records) != 5 {
+		t.Fatalf("got %d records, want 5: %v", len(records), records)
+	}
+
+	file := records["/etc/with  space"]
+	if file.Dev != 2049 || file.INode != 131 || file.Mode != 0o644 || file.Size != 42 || file.User != "alice" {
+		t.Errorf("/etc/with  space = %+v", file)
+	}
+	if want := time.Unix(1704164645, 0); !file.ModTime.Equal(want) {
+		t.Errorf("/etc/with  space modification time = %v, want %v", file.ModTime, want)
+	}
+
+	link := records["/etc/link"]
+	if !link.IsSymlink || link.LinkTarget != "../usr/target" || link.Mode != os.ModeSymlink|0o777 {
+		t.Errorf("/etc/link = %+v", link)
+	}
+
+	dir := records["/etc/dir"]
+	if dir.Type != "dir" || dir.Mode != os.ModeDir|os.ModeSticky|0o775 || !dir.Sticky || dir.NLink != 2 {
+		t.Errorf("/etc/dir = %+v", dir)
+	}
+
+	if tty := records["/dev/tty0"]; tty.Mode != os.ModeDevice|os.ModeCharDevice|0o660 {
+		t.Errorf("/dev/tty0 = %+v", tty)
+	}
+	if sudo := records["/usr/bin/sudo"]; sudo.Mode != os.ModeSetuid|0o755 || !sudo.SetUID {
+		t.Errorf("/usr/bin/sudo = %+v", sudo)
+	}
+}
+
+func TestUnixMode(t *testing.T) {
+	tests := []struct {
+		in   uint32
+		want os.FileMode
+	}{
+		{in: 0o100644, want: 0o644},
+		{in: 0o040755, want: os.ModeDir | 0o755},
+		{in: 0o120777, want: os.ModeSymlink | 0o777},
+		{in: 0o020620, want: os.ModeDevice | os.ModeCharDevice | 0o620},
+		{in: 0o060660, want: os.ModeDevice | 0o660},
+		{in: 0o010600, want: os.ModeNamedPipe | 0o600},
+		{in: 0o140777, want: os.ModeSocket | 0o777},
+		{in: 0o106755, want: os.ModeSetuid | os.ModeSetgid | 0o755},
+		{in: 0o041777, want: os.ModeDir | os.ModeSticky | 0o777},
+	}
+
+	for _, tt := range tests {
+		if got := unixMode(tt.in); got != tt.want {
+			t.Errorf("unixMode(%#o) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
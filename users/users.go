@@ -12,7 +12,12 @@ import (
 )
 
 func GetAllUsers() ([]model.User, error) {
-	file, err := os.Open("/etc/passwd")
+	return ReadUsers("/etc/passwd")
+}
+
+// ReadUsers reads the users from a file in the format of /etc/passwd, such as a copy taken from another system.
+func ReadUsers(path string) ([]model.User, error) {
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,459 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	model "github.com/mykeelium/lshound/model"
+)
+
+// graphSink receives the nodes and edges of a graph in the order they are made.
+type graphSink interface {
+	writeNode(node model.Node) error
+	writeEdge(edge model.Edge) error
+}
+
+// memorySink keeps the whole graph in memory.
+type memorySink struct {
+	nodes []model.Node
+	edges []model.Edge
+}
+
+func (s *memorySink) writeNode(node model.Node) error {
+	s.nodes = append(s.nodes, node)
+	return nil
+}
+
+func (s *memorySink) writeEdge(edge model.Edge) error {
+	s.edges = append(s.edges, edge)
+	return nil
+}
+
+// arrayWriter writes the elements of a JSON array one at a time, laid out as json.MarshalIndent would with
+// the array's opening bracket already written at the given indent.
+type arrayWriter struct {
+	w      io.Writer
+	indent string
+	count  int
+}
+
+func (a *arrayWriter) write(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	element, err := a.format(data)
+	if err != nil {
+		return err
+	}
+	return a.writeFormatted(element)
+}
+
+// format lays out an element given as compact JSON, without the separator in front of it.
+func (a *arrayWriter) format(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(a.indent + "  ")
+	if err := json.Indent(&b, data, a.indent+"  ", "  "); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (a *arrayWriter) writeFormatted(element []byte) error {
+	separator := ",\n"
+	if a.count == 0 {
+		separator = "\n"
+	}
+	a.count++
+	if _, err := io.WriteString(a.w, separator); err != nil {
+		return err
+	}
+	_, err := a.w.Write(element)
+	return err
+}
+
+// end returns what closes the array.
+func (a *arrayWriter) end() string {
+	if a.count == 0 {
+		return "]"
+	}
+	return "\n" + a.indent + "]"
+}
+
+// ChunkLimits splits a graph into several documents. A chunk ends once it holds Items nodes and edges or
+// would grow past Bytes bytes of JSON, before compression. Zero means no limit.
+type ChunkLimits struct {
+	Items int
+	Bytes int64
+}
+
+func (l ChunkLimits) Chunked() bool {
+	return l.Items > 0 || l.Bytes > 0
+}
+
+// graphEncoder writes an OpenGraph document as its nodes and edges are made. Nodes go straight to the output,
+// edges are spilled to a temporary file and copied in once all nodes are written, so neither is held in memory.
+// When the limits are reached the document is closed and continued in the next file of the destination. Every
+// chunk stands on its own: the nodes come before the edges, so a chunk of edges repeats the nodes they connect
+// as stubs of only their ID and kind, which BloodHound merges with the full node from another chunk.
+type graphEncoder struct {
+	dest   *Destination
+	limits ChunkLimits
+	out    *bufio.Writer
+	// size and items are what has been written to the current chunk.
+	size     int64
+	items    int
+	inEdges  bool
+	nodes    arrayWriter
+	edges    arrayWriter
+	spill    *os.File
+	spillOut *bufio.Writer
+	// kinds holds the kind of each node whose kind cannot be told from its ID, for the stubs. chunkNodes holds
+	// the IDs of the nodes in the current chunk, which need no stub. Both are only kept when chunked.
+	kinds      map[string]string
+	chunkNodes map[string]bool
+}
+
+const (
+	graphHeader   = "{\n  \"graph\": {\n    \"nodes\": ["
+	edgesHeader   = ",\n    \"edges\": ["
+	graphTrailer  = "\n  }\n}\n"
+	elementIndent = "    "
+)
+
+func newGraphEncoder(dest *Destination, limits ChunkLimits) (*graphEncoder, error) {
+	spill, err := os.CreateTemp("", "lshound-edges-*.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	e := &graphEncoder{
+		dest:     dest,
+		limits:   limits,
+		spill:    spill,
+		spillOut: bufio.NewWriter(spill),
+	}
+	if limits.Chunked() {
+		e.kinds = map[string]string{}
+	}
+	if err := e.startChunk(); err != nil {
+		e.remove()
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *graphEncoder) writeNode(node model.Node) error {
+	data, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	if err := e.writeElement(&e.nodes, data); err != nil {
+		return err
+	}
+	if e.limits.Chunked() {
+		e.chunkNodes[node.ID] = true
+		if len(node.Kinds) > 0 && node.Kinds[0] != stubKind(node.ID) {
+			e.kinds[node.ID] = node.Kinds[0]
+		}
+	}
+	return nil
+}
+
+// writeEdge spills the edge as a line of compact JSON.
+func (e *graphEncoder) writeEdge(edge model.Edge) error {
+	data, err := json.Marshal(edge)
+	if err != nil {
+		return err
+	}
+	if _, err := e.spillOut.Write(data); err != nil {
+		return err
+	}
+	return e.spillOut.WriteByte('\n')
+}
+
+// finish writes the spilled edges after the nodes and closes the last document.
+func (e *graphEncoder) finish() error {
+	if err := e.spillOut.Flush(); err != nil {
+		return err
+	}
+	if e.limits.Chunked() {
+		if err := e.writeChunkedEdges(); err != nil {
+			return err
+		}
+		return e.dest.Close()
+	}
+	if _, err := e.spill.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := e.write(e.nodes.end() + edgesHeader); err != nil {
+		return err
+	}
+	e.inEdges = true
+
+	spilled := bufio.NewReader(e.spill)
+	for {
+		line, err := spilled.ReadBytes('\n')
+		if len(line) > 1 {
+			if err := e.writeElement(&e.edges, line[:len(line)-1]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := e.endChunk(); err != nil {
+		return err
+	}
+	return e.dest.Close()
+}
+
+// writeChunkedEdges writes the spilled edges into as many chunks as they take, each along with the stubs of
+// the nodes its edges connect. The edges that fit are found first and then read again to be written, so that
+// neither they nor the stubs have to come after the edges.
+func (e *graphEncoder) writeChunkedEdges() error {
+	end, err := e.spill.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	for offset := int64(0); offset < end; {
+		count, length, stubs, err := e.planEdges(offset)
+		if err != nil {
+			return err
+		}
+		// A chunk that is full of nodes is closed, the next one has room for at least one edge.
+		if count > 0 {
+			for _, stub := range stubs {
+				if err := e.nodes.write(stub); err != nil {
+					return err
+				}
+			}
+			if err := e.write(e.nodes.end() + edgesHeader); err != nil {
+				return err
+			}
+			e.inEdges = true
+			if err := e.copyEdges(offset, count); err != nil {
+				return err
+			}
+			offset += length
+		}
+
+		if err := e.endChunk(); err != nil {
+			return err
+		}
+		e.inEdges = false
+		if offset < end {
+			if err := e.startChunk(); err != nil {
+				return err
+			}
+		}
+	}
+	if end == 0 {
+		return e.endChunk()
+	}
+	return nil
+}
+
+// copyEdges writes count spilled edges from offset on to the current chunk.
+func (e *graphEncoder) copyEdges(offset int64, count int) error {
+	if _, err := e.spill.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	spilled := bufio.NewReader(e.spill)
+	for range count {
+		line, err := spilled.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		element, err := e.edges.format(line[:len(line)-1])
+		if err != nil {
+			return err
+		}
+		if err := e.edges.writeFormatted(element); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planEdges finds how many of the spilled edges from offset on fit in the current chunk together with the stubs
+// they need, and how many bytes of the spill file they take. A chunk without elements always takes one edge.
+func (e *graphEncoder) planEdges(offset int64) (count int, length int64, stubs []model.Node, err error) {
+	if _, err := e.spill.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, nil, err
+	}
+	spilled := bufio.NewReader(e.spill)
+	items, size := e.items, e.size+int64(len(edgesHeader))
+	stubbed := map[string]bool{}
+	for {
+		line, err := spilled.ReadBytes('\n')
+		if err == io.EOF {
+			return count, length, stubs, nil
+		}
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		var edge model.Edge
+		if err := json.Unmarshal(line, &edge); err != nil {
+			return 0, 0, nil, err
+		}
+
+		element, err := e.edges.format(line[:len(line)-1])
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		added, addedSize := 1, int64(len(element))+2
+		var needed []model.Node
+		for _, id := range []string{edge.Start.Value, edge.End.Value} {
+			if e.chunkNodes[id] || stubbed[id] {
+				continue
+			}
+			stub := e.stub(id)
+			data, err := json.Marshal(stub)
+			if err != nil {
+				return 0, 0, nil, err
+			}
+			element, err := e.nodes.format(data)
+			if err != nil {
+				return 0, 0, nil, err
+			}
+			added++
+			addedSize += int64(len(element)) + 2
+			needed = append(needed, stub)
+			stubbed[id] = true
+		}
+		if items > 0 && e.exceeds(items+added, size+addedSize) {
+			return count, length, stubs, nil
+		}
+
+		items += added
+		size += addedSize
+		count++
+		length += int64(len(line))
+		stubs = append(stubs, needed...)
+	}
+}
+
+// stub returns the node of only the ID and kind that stands in for a node written to another chunk.
+func (e *graphEncoder) stub(id string) model.Node {
+	kind, ok := e.kinds[id]
+	if !ok {
+		kind = stubKind(id)
+	}
+	return model.Node{ID: id, Kinds: []string{kind}}
+}
+
+// stubKind tells the kind of a node from its ID, which holds for all but the files that are not regular ones.
+func stubKind(id string) string {
+	switch {
+	case strings.HasPrefix(id, "uid-"):
+		return "User"
+	case strings.HasPrefix(id, "gid-"):
+		return "Group"
+	case strings.HasPrefix(id, "cap-"):
+		return "Capability"
+	default:
+		return "file"
+	}
+}
+
+// remove deletes the spill file, it is called once the encoder is done with whether or not it finished.
+func (e *graphEncoder) remove() {
+	e.spill.Close()
+	os.Remove(e.spill.Name())
+}
+
+func (e *graphEncoder) writeElement(array *arrayWriter, data []byte) error {
+	element, err := array.format(data)
+	if err != nil {
+		return err
+	}
+	if e.full(int64(len(element))) {
+		if err := e.endChunk(); err != nil {
+			return err
+		}
+		if err := e.startChunk(); err != nil {
+			return err
+		}
+	}
+	e.items++
+	e.size += int64(len(element)) + 2
+	return array.writeFormatted(element)
+}
+
+// full tells whether the current chunk has no room for another element of the given size. A chunk always
+// takes at least one element, however large.
+func (e *graphEncoder) full(size int64) bool {
+	return e.items > 0 && e.exceeds(e.items+1, e.size+size)
+}
+
+// exceeds tells whether a chunk of the given number of elements and size goes past the limits.
+func (e *graphEncoder) exceeds(items int, size int64) bool {
+	if e.limits.Items > 0 && items > e.limits.Items {
+		return true
+	}
+	reserved := int64(len(edgesHeader) + len(graphTrailer) + 2*len(elementIndent) + 4)
+	return e.limits.Bytes > 0 && size+reserved > e.limits.Bytes
+}
+
+// startChunk opens the next file and writes the document up to where the next element goes.
+func (e *graphEncoder) startChunk() error {
+	w, err := e.dest.Next()
+	if err != nil {
+		return err
+	}
+	e.out = bufio.NewWriter(w)
+	e.size, e.items = 0, 0
+	e.nodes = arrayWriter{w: e.out, indent: elementIndent}
+	e.edges = arrayWriter{w: e.out, indent: elementIndent}
+	if e.limits.Chunked() {
+		e.chunkNodes = map[string]bool{}
+	}
+	return e.write(graphHeader)
+}
+
+// endChunk closes the document in the current file.
+func (e *graphEncoder) endChunk() error {
+	trailer := e.nodes.end() + edgesHeader + "]" + graphTrailer
+	if e.inEdges {
+		trailer = e.edges.end() + graphTrailer
+	}
+	if err := e.write(trailer); err != nil {
+		return err
+	}
+	return e.out.Flush()
+}
+
+func (e *graphEncoder) write(s string) error {
+	e.size += int64(len(s))
+	_, err := e.out.WriteString(s)
+	return err
+}
+
+// writeField writes a member of a top level object, laid out as json.MarshalIndent would.
+func writeField(w io.Writer, name string, v any) error {
+	data, err := json.MarshalIndent(v, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("  \"" + name + "\": ")
+	b.Write(data)
+	b.WriteString(",\n")
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// drain consumes what is left on the channel so that the producers are not blocked after an output error.
+func drain(fileChannel chan model.FileInfoRecord) {
+	for range fileChannel {
+	}
+}
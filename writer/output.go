@@ -0,0 +1,130 @@
+package writer
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Compression modes of a Destination.
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZip  = "zip"
+)
+
+// Destination creates the files a document is written to. Chunked documents get a numbered file per chunk,
+// which are entries of a single archive when zipped.
+type Destination struct {
+	name        string
+	stdout      io.Writer
+	compression string
+	chunked     bool
+	count       int
+
+	archive     *zip.Writer
+	archiveFile *os.File
+	file        *os.File
+	gzip        *gzip.Writer
+}
+
+// NewDestination returns a destination that creates name.json, or name-0001.json and onwards when chunked, with
+// .gz appended for gzip and all inside name.zip for zip. When stdout is not nil everything is written to it
+// instead, which can only hold several chunks as a zip archive.
+func NewDestination(name string, stdout io.Writer, compression string, chunked bool) (*Destination, error) {
+	switch compression {
+	case CompressNone, CompressGzip, CompressZip:
+	default:
+		return nil, fmt.Errorf("unknown compression %q, expected %s, %s or %s", compression, CompressNone, CompressGzip, CompressZip)
+	}
+	if stdout != nil && chunked && compression != CompressZip {
+		return nil, errors.New("chunked output can only be written to stdout as a zip archive")
+	}
+	return &Destination{
+		name:        name,
+		stdout:      stdout,
+		compression: compression,
+		chunked:     chunked,
+	}, nil
+}
+
+// Next finishes the current file and returns a writer for the next one.
+func (d *Destination) Next() (io.Writer, error) {
+	if err := d.closeFile(); err != nil {
+		return nil, err
+	}
+	d.count++
+	name := d.name + ".json"
+	if d.chunked {
+		name = fmt.Sprintf("%s-%04d.json", d.name, d.count)
+	}
+
+	switch d.compression {
+	case CompressZip:
+		if d.archive == nil {
+			w := d.stdout
+			if w == nil {
+				f, err := os.Create(d.name + ".zip")
+				if err != nil {
+					return nil, err
+				}
+				d.archiveFile = f
+				w = f
+			}
+			d.archive = zip.NewWriter(w)
+		}
+		// Entries are named after the file alone, the directory of the output is where the archive is.
+		return d.archive.CreateHeader(&zip.FileHeader{Name: filepath.Base(name), Method: zip.Deflate, Modified: time.Now()})
+	case CompressGzip:
+		w, err := d.open(name + ".gz")
+		if err != nil {
+			return nil, err
+		}
+		d.gzip = gzip.NewWriter(w)
+		return d.gzip, nil
+	default:
+		return d.open(name)
+	}
+}
+
+// Close finishes the last file and the archive, if any.
+func (d *Destination) Close() error {
+	err := d.closeFile()
+	if d.archive != nil {
+		err = errors.Join(err, d.archive.Close())
+	}
+	if d.archiveFile != nil {
+		err = errors.Join(err, d.archiveFile.Close())
+	}
+	return err
+}
+
+func (d *Destination) open(name string) (io.Writer, error) {
+	if d.stdout != nil {
+		return d.stdout, nil
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	d.file = f
+	return f, nil
+}
+
+func (d *Destination) closeFile() error {
+	var err error
+	if d.gzip != nil {
+		err = d.gzip.Close()
+		d.gzip = nil
+	}
+	if d.file != nil {
+		err = errors.Join(err, d.file.Close())
+		d.file = nil
+	}
+	return err
+}
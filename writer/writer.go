@@ -2,17 +2,28 @@
 package writer
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 
+	"github.com/mykeelium/lshound/capabilities"
+	"github.com/mykeelium/lshound/files"
+	"github.com/mykeelium/lshound/graph"
+	"github.com/mykeelium/lshound/identity"
 	model "github.com/mykeelium/lshound/model"
 )
 
 func CreateBaseCollection(users []model.User, groups []model.Group, fileChannel chan model.FileInfoRecord) model.CollectionEnvelope {
 	fileSystemItems := []model.FileInfoRecord{}
 	for file := range fileChannel {
-		fileSystemItems = append(fileSystemItems, file)
+		if !file.DirDone {
+			fileSystemItems = append(fileSystemItems, file)
+		}
 	}
 
 	return model.CollectionEnvelope{
@@ -22,12 +33,98 @@ func CreateBaseCollection(users []model.User, groups []model.Group, fileChannel
 	}
 }
 
-func CreateGraph(users []model.User, groups []model.Group, fileChannel chan model.FileInfoRecord) model.GraphEnvelope {
-	nodes := []model.Node{}
-	edges := []model.Edge{}
-	for _, group := range groups {
-		nodes = append(nodes, model.Node{
-			ID:    fmt.Sprintf("gid-%d", group.GID),
+// WriteBaseCollection writes the same document as CreateBaseCollection, but writes each record as it arrives
+// instead of holding on to them. The channel is always drained, even when writing fails.
+func WriteBaseCollection(w io.Writer, users []model.User, groups []model.Group, fileChannel chan model.FileInfoRecord) error {
+	defer drain(fileChannel)
+
+	out := bufio.NewWriter(w)
+	if _, err := out.WriteString("{\n"); err != nil {
+		return err
+	}
+	if err := writeField(out, "users", users); err != nil {
+		return err
+	}
+	if err := writeField(out, "groups", groups); err != nil {
+		return err
+	}
+	if _, err := out.WriteString("  \"file_system_items\": ["); err != nil {
+		return err
+	}
+	items := arrayWriter{w: out, indent: "  "}
+	for file := range fileChannel {
+		if file.DirDone {
+			continue
+		}
+		if err := items.write(file); err != nil {
+			return err
+		}
+	}
+	if _, err := out.WriteString(items.end() + "\n}\n"); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// GraphOptions changes how the graph is derived from the collected records.
+type GraphOptions struct {
+	// PruneUnreachable drops access edges for principals that cannot search every parent directory of
+	// the target, rather than keeping them with reachable=false.
+	PruneUnreachable bool
+	// PerUser evaluates every user's access to each file under POSIX precedence and emits edges from the
+	// users themselves, in place of the owner, group and other edges.
+	PerUser bool
+	// Lookup is used to look at the directories above missing symlink targets that were not collected. It
+	// is nil when the records describe another system, which can't be looked at.
+	Lookup *files.Options
+}
+
+func CreateGraph(index *identity.Index, fileChannel chan model.FileInfoRecord, opts GraphOptions) model.GraphEnvelope {
+	sink := &memorySink{nodes: []model.Node{}, edges: []model.Edge{}}
+	buildGraph(sink, index, fileChannel, opts)
+	return model.GraphEnvelope{
+		Graph: model.Graph{
+			Nodes: sink.nodes,
+			Edges: sink.edges,
+		},
+	}
+}
+
+// WriteGraph writes the same document as CreateGraph to dest while the records arrive, split into chunks
+// when limits are set. Only what later records depend on is kept, the directories the walk has not left
+// yet and files with several hard links that are not complete yet. The channel is always drained, even when writing fails.
+func WriteGraph(dest *Destination, limits ChunkLimits, index *identity.Index, fileChannel chan model.FileInfoRecord, opts GraphOptions) error {
+	encoder, err := newGraphEncoder(dest, limits)
+	if err != nil {
+		drain(fileChannel)
+		return err
+	}
+	defer encoder.remove()
+
+	if err := buildGraph(encoder, index, fileChannel, opts); err != nil {
+		return err
+	}
+	return encoder.finish()
+}
+
+// buildGraph derives the graph from the users, groups and records and hands it to sink. It returns the
+// first error of the sink, after which nothing more is written but the channel is still read to the end.
+func buildGraph(sink graphSink, index *identity.Index, fileChannel chan model.FileInfoRecord, opts GraphOptions) error {
+	// Root and holders of the DAC capabilities are not checked against file permissions at all. Rather than
+	// an edge to every file, the bypass is recorded on the user and they search every directory.
+	var identities []graph.Identity
+	for _, user := range index.Users() {
+		identities = append(identities, graph.Identity{
+			UID:       user.UID,
+			GIDs:      index.GroupsOf(user.UID),
+			SearchAll: user.UID == 0 || dacReadSearch(user.Capabilities),
+		})
+	}
+	b := newGraphBuilder(sink, opts, identities)
+
+	for _, group := range index.Groups() {
+		b.writeNode(model.Node{
+			ID:    graph.GroupID(group.GID),
 			Kinds: []string{"Group"},
 			Title: group.Name,
 			Properties: map[string]string{
@@ -35,297 +132,839 @@ func CreateGraph(users []model.User, groups []model.Group, fileChannel chan mode
 			},
 		})
 		for _, member := range group.Members {
-			for _, user := range users {
-				if member == user.Username {
-					edges = append(edges, model.Edge{
-						Kind: "InGroup",
-						Start: model.Connection{
-							Value:   fmt.Sprintf("uid-%d", user.UID),
-							MatchBy: "id",
-						},
-						End: model.Connection{
-							Value:   fmt.Sprintf("gid-%d", group.GID),
-							MatchBy: "id",
-						},
-					})
-				}
+			if uid, ok := index.UserID(member); ok {
+				b.writeEdge(idEdge("InGroup", graph.UserID(uid), graph.GroupID(group.GID), nil))
 			}
 		}
 	}
-	for _, user := range users {
-		nodes = append(nodes, model.Node{
-			ID:    fmt.Sprintf("uid-%d", user.UID),
-			Kinds: []string{"User"},
-			Title: user.Username,
-			Properties: map[string]string{
-				"name":  user.Username,
-				"shell": user.Shell,
-				"home":  user.Home,
-				"gid":   fmt.Sprintf("%d", user.GID),
-			},
-		})
+	for _, user := range index.Users() {
+		dacOverride := user.UID == 0 || capabilities.Contains(user.Capabilities, capabilities.DACOverride)
 
-		edges = append(edges, model.Edge{
-			Kind: "InGroup",
-			Start: model.Connection{
-				Value:   fmt.Sprintf("uid-%d", user.UID),
-				MatchBy: "id",
-			},
-			End: model.Connection{
-				Value:   fmt.Sprintf("gid-%d", user.GID),
-				MatchBy: "id",
-			},
+		properties := map[string]string{
+			"name":            user.Username,
+			"shell":           user.Shell,
+			"home":            user.Home,
+			"gid":             fmt.Sprintf("%d", user.GID),
+			"capabilities":    strings.Join(user.Capabilities, ","),
+			"dac_override":    strconv.FormatBool(dacOverride),
+			"dac_read_search": strconv.FormatBool(user.UID == 0 || dacReadSearch(user.Capabilities)),
+		}
+		if user.UID == 0 {
+			properties["highvalue"] = "true"
+			properties["system_tags"] = "admin_tier_0"
+		}
+		b.writeNode(model.Node{
+			ID:         graph.UserID(user.UID),
+			Kinds:      []string{"User"},
+			Title:      user.Username,
+			Properties: properties,
 		})
+
+		b.writeEdge(idEdge("InGroup", graph.UserID(user.UID), graph.GroupID(user.GID), nil))
+		b.writeEdge(idEdge("InGroup", graph.UserID(user.UID), graph.EveryoneID, nil))
 	}
 
-	nodes = append(nodes, model.Node{
-		ID:          "uid-other",
-		Kinds:       []string{"User"},
-		Title:       "Other",
-		Description: "This is used for all other users that are not the owner or in the group for a specific file",
+	b.writeNode(model.Node{
+		ID:          graph.EveryoneID,
+		Kinds:       []string{"Group"},
+		Title:       "Everyone",
+		Description: "Every user is a member of this group, it holds the other permissions of each file. The owner and group members of a file are listed as excluded on edges they do not get through the other permissions",
+		Properties: map[string]string{
+			"name": "Everyone",
+		},
 	})
 
 	for file := range fileChannel {
-		nodes = append(nodes, model.Node{
-			ID:    fmt.Sprintf("inode-%d", file.INode),
-			Title: file.Path,
-			Kinds: []string{file.Type},
-			Properties: map[string]string{
-				"name":        file.Path,
-				"type":        file.Type,
-				"mode_string": file.ModeString,
-				"mode_octal":  file.ModeOctal,
-				"uid":         fmt.Sprintf("uid-%d", file.UID),
-				"owner":       file.User,
-				"gid":         fmt.Sprintf("gid-%d", file.GID),
-				"group":       file.Group,
-				"is_sym_link": strconv.FormatBool(file.IsSymlink),
-				"link_target": file.LinkTarget,
-				"size":        fmt.Sprintf("%d", file.Size),
-			},
-		})
+		if b.err == nil {
+			b.addFile(file)
+		}
+	}
+	b.finish()
+	return b.err
+}
 
-		edges = append(edges, model.Edge{
-			Kind: "Owns",
-			Start: model.Connection{
-				Value:   fmt.Sprintf("uid-%d", file.UID),
-				MatchBy: "id",
-			},
-			End: model.Connection{
-				Value:   fmt.Sprintf("inode-%d", file.INode),
-				MatchBy: "id",
-			},
-		})
+// graphBuilder turns file records into nodes and edges. It remembers the directories it has seen, until the
+// walk is done with them, so that edges which depend on the parent directory can be derived for their children.
+type graphBuilder struct {
+	sink graphSink
+	// err is the first error of the sink, nothing is written once it is set.
+	err        error
+	opts       GraphOptions
+	identities []graph.Identity
+	// members holds the users each user, group and Everyone principal stands for.
+	members map[string][]graph.Identity
+	dirs    map[string]dirEntry
+	// capabilities holds the capabilities that already have a node.
+	capabilities map[string]bool
+	// orphans holds children that arrived before their parent directory, keyed by the parent path.
+	orphans map[string][]childEntry
+	// missingTargets holds the symlinks pointing at each missing target, keyed by the target path.
+	missingTargets map[string][]string
+	// missingDirs holds the missing directories above missing targets that already have a node.
+	missingDirs map[string]bool
+	// linkEdges holds the PointsTo edges that were added, as start and end ID separated by a space.
+	linkEdges map[string]bool
+	// links holds the nodes of files with more than one hard link until every link has been seen, so that
+	// further links merge into them. The entry is nil once the node has been written.
+	links map[string]*linkedNode
+}
 
-		edges = append(edges, model.Edge{
-			Kind: "Owns",
-			Start: model.Connection{
-				Value:   fmt.Sprintf("gid-%d", file.GID),
-				MatchBy: "id",
-			},
-			End: model.Connection{
-				Value:   fmt.Sprintf("inode-%d", file.INode),
-				MatchBy: "id",
-			},
+type linkedNode struct {
+	node  model.Node
+	nlink uint64
+	paths []string
+	// file, grants and reach make the access edges, which wait with the node so that every link is taken into
+	// account: access through any link is usable by whoever can get to that link.
+	file   model.FileInfoRecord
+	grants []graph.Grant
+	reach  graph.Reach
+}
+
+type dirEntry struct {
+	id    string
+	owner string
+	// sticky directories only let the owner of a child, the owner of the directory or root remove the child.
+	sticky bool
+	// writers are the principals that can both write and search the directory, and so can unlink and create entries in it.
+	writers []string
+	// reach holds the users that can search this directory and every directory above it.
+	reach graph.Reach
+	// locked directories are immutable, append-only or read-only mounted, nothing in them can be removed or renamed.
+	locked bool
+}
+
+type childEntry struct {
+	id    string
+	owner string
+	isDir bool
+	// locked children are immutable, append-only or mount points and cannot be removed or renamed themselves.
+	locked bool
+}
+
+func newGraphBuilder(sink graphSink, opts GraphOptions, identities []graph.Identity) *graphBuilder {
+	members := map[string][]graph.Identity{}
+	for _, identity := range identities {
+		members[graph.UserID(identity.UID)] = append(members[graph.UserID(identity.UID)], identity)
+		members[graph.EveryoneID] = append(members[graph.EveryoneID], identity)
+		for gid := range identity.GIDs {
+			members[graph.GroupID(gid)] = append(members[graph.GroupID(gid)], identity)
+		}
+	}
+	return &graphBuilder{
+		sink:           sink,
+		opts:           opts,
+		identities:     identities,
+		members:        members,
+		dirs:           map[string]dirEntry{},
+		capabilities:   map[string]bool{},
+		orphans:        map[string][]childEntry{},
+		links:          map[string]*linkedNode{},
+		missingTargets: map[string][]string{},
+		missingDirs:    map[string]bool{},
+		linkEdges:      map[string]bool{},
+	}
+}
+
+func (b *graphBuilder) writeNode(node model.Node) {
+	if b.err == nil {
+		b.err = b.sink.writeNode(node)
+	}
+}
+
+func (b *graphBuilder) writeEdge(edge model.Edge) {
+	if b.err == nil {
+		b.err = b.sink.writeEdge(edge)
+	}
+}
+
+func (b *graphBuilder) addFile(file model.FileInfoRecord) {
+	// Nothing more is placed in a directory the walk has left, so it is forgotten.
+	if file.DirDone {
+		delete(b.dirs, file.Path)
+		return
+	}
+	if file.Placeholder {
+		b.addTargetPlaceholder(file)
+		return
+	}
+	fileID := fileNodeID(file)
+	isDir := file.Type == "dir"
+	hardLinked := !isDir && file.NLink > 1
+	if hardLinked {
+		if linked, ok := b.links[fileID]; ok {
+			b.addLink(fileID, linked, file)
+			return
+		}
+	}
+
+	seUser, seRole, seType, seLevel := selinuxContext(file.SELinuxLabel)
+	node := model.Node{
+		ID:    fileID,
+		Title: file.Path,
+		Kinds: []string{file.Type},
+		Properties: map[string]string{
+			"name":          file.Path,
+			"paths":         file.Path,
+			"logical_path":  file.LogicalPath,
+			"type":          file.Type,
+			"mode_string":   file.ModeString,
+			"mode_octal":    file.ModeOctal,
+			"uid":           graph.UserID(file.UID),
+			"owner":         file.User,
+			"gid":           graph.GroupID(file.GID),
+			"group":         file.Group,
+			"is_sym_link":   strconv.FormatBool(file.IsSymlink),
+			"link_target":   file.LinkTarget,
+			"size":          fmt.Sprintf("%d", file.Size),
+			"dev":           fmt.Sprintf("%d", file.Dev),
+			"inode":         fmt.Sprintf("%d", file.INode),
+			"nlink":         fmt.Sprintf("%d", file.NLink),
+			"sticky":        strconv.FormatBool(file.Sticky),
+			"ancestor":      strconv.FormatBool(file.Ancestor),
+			"capabilities":  strings.Join(file.Capabilities, ","),
+			"selinux_user":  seUser,
+			"selinux_role":  seRole,
+			"selinux_type":  seType,
+			"selinux_level": seLevel,
+			"smack_label":   file.SMACKLabel,
+			"attributes":    strings.Join(file.Attributes, ","),
+			"immutable":     strconv.FormatBool(file.Immutable),
+			"append_only":   strconv.FormatBool(file.AppendOnly),
+			"mount_point":   file.MountPoint,
+			"fs_type":       file.FSType,
+			"mount_options": strings.Join(file.MountOptions, ","),
+		},
+	}
+	if !hardLinked {
+		b.writeNode(node)
+	}
+
+	b.writeEdge(idEdge("Owns", graph.UserID(file.UID), fileID, nil))
+	b.writeEdge(idEdge("Owns", graph.GroupID(file.GID), fileID, nil))
+
+	// UID and GID ExecuteAs edges. Currently only set if the corresponding execute bit is set. Neither
+	// these nor file capabilities take effect on nosuid mounts, and nothing on noexec mounts is executed.
+	privileged := !hasMountOption(file, "nosuid") && !hasMountOption(file, "noexec")
+	if privileged && file.SetUID && ownerCanExecute(file.Mode) {
+		b.writeEdge(idEdge("ExecuteAs", fileID, graph.UserID(file.UID), nil))
+	}
+	if privileged && file.SetGID && groupCanExecute(file.Mode) {
+		b.writeEdge(idEdge("ExecuteAs", fileID, graph.GroupID(file.GID), nil))
+	}
+
+	// File capabilities are granted to whoever executes the file. Namespaced capabilities only apply
+	// inside the user namespace whose root is CapRootID, so they don't lead anywhere on the host.
+	for _, capability := range file.Capabilities {
+		if !privileged {
+			break
+		}
+		kind := "HasCapability"
+		if file.CapRootID != 0 {
+			kind = "HasNamespacedCapability"
+		}
+		b.writeEdge(idEdge(kind, fileID, b.capabilityNode(capability), map[string]string{
+			"effective": strconv.FormatBool(file.CapEffective),
+			"root_id":   fmt.Sprintf("%d", file.CapRootID),
+		}))
+	}
+
+	parentReach := b.parentReach(file.Path)
+
+	// The mode of a symlink is always 0777 and never checked, access is decided by its target.
+	var grants []graph.Grant
+	if !file.IsSymlink {
+		grants = b.grants(file)
+	}
+	// The paths of a hard-linked file are only known once each link has been seen, its node waits until then.
+	if hardLinked {
+		b.links[fileID] = &linkedNode{
+			node:   node,
+			nlink:  file.NLink,
+			paths:  []string{file.Path},
+			file:   file,
+			grants: grants,
+			reach:  parentReach,
+		}
+	} else {
+		b.addAccessEdges(file, fileID, grants, parentReach)
+	}
+
+	var dir dirEntry
+	if isDir {
+		dir = dirEntry{
+			id:     fileID,
+			owner:  graph.UserID(file.UID),
+			sticky: file.Sticky,
+			locked: file.Immutable || file.AppendOnly || hasMountOption(file, "ro"),
+		}
+		dir.reach = parentReach.Narrow(file, b.identities)
+		for _, grant := range grants {
+			if grant.Perm&(graph.PermWrite|graph.PermExecute) == graph.PermWrite|graph.PermExecute {
+				dir.writers = append(dir.writers, grant.Principal)
+			}
+		}
+	}
+
+	// Default ACL entries on a directory grant access to files that are created in it later on.
+	for _, grant := range graph.InheritedGrants(file) {
+		if grant.Perm == 0 {
+			continue
+		}
+		b.addAccessEdge("InheritsAccessTo", grant.Principal, fileID, dir.reach, map[string]string{
+			"permissions": permString(grant.Perm),
+			"source":      grant.Source,
 		})
+	}
+
+	b.attachToParent(file, fileID)
+	b.addLinkChain(fileID, file.LinkChain)
+
+	if isDir {
+		b.dirs[file.Path] = dir
+		for _, orphan := range b.orphans[file.Path] {
+			b.addChildEdges(dir, orphan)
+		}
+		delete(b.orphans, file.Path)
+	}
+}
+
+// addLink merges another hard link to an already added file into its node. Only the edges that depend on
+// where the link is, those from its parent directory, are added for it. The node is written once all of its
+// links are seen, links that show up after that, such as ones made during the walk, only get their edges.
+func (b *graphBuilder) addLink(fileID string, linked *linkedNode, file model.FileInfoRecord) {
+	if linked == nil {
+		b.attachToParent(file, fileID)
+		return
+	}
+	for _, path := range linked.paths {
+		if path == file.Path {
+			return
+		}
+	}
+	linked.paths = append(linked.paths, file.Path)
+	linked.reach = linked.reach.Union(b.parentReach(file.Path))
+	b.attachToParent(file, fileID)
+	if uint64(len(linked.paths)) >= linked.nlink {
+		b.writeLinkedNode(fileID, linked)
+	}
+}
+
+func (b *graphBuilder) writeLinkedNode(fileID string, linked *linkedNode) {
+	linked.node.Properties["paths"] = strings.Join(linked.paths, ",")
+	b.writeNode(linked.node)
+	b.addAccessEdges(linked.file, fileID, linked.grants, linked.reach)
+	b.links[fileID] = nil
+}
+
+// parentReach returns the users that can get to the entries of the parent directory of path. When the parent
+// has not been seen yet, which only happens for unordered input, nobody is assumed to be stopped on the way.
+func (b *graphBuilder) parentReach(path string) graph.Reach {
+	if parent, ok := b.dirs[filepath.Dir(path)]; ok {
+		return parent.reach
+	}
+	return graph.Everyone()
+}
+
+// addAccessEdges adds the edges of what each principal is granted on the file. Access is only usable by the
+// principals that can get to the file, those in reach.
+func (b *graphBuilder) addAccessEdges(file model.FileInfoRecord, fileID string, grants []graph.Grant, reach graph.Reach) {
+	for _, grant := range grants {
+		kinds := permissionEdgeKinds(grant.Perm)
+		if file.Type == "dir" {
+			kinds = directoryEdgeKinds(grant.Perm)
+		}
+		for _, kind := range restrictKinds(file, kinds) {
+			properties := map[string]string{
+				"source": grant.Source,
+			}
+			if grant.Principal == graph.EveryoneID {
+				if excluded := graph.Excluded(grants, edgeKindPerms[kind]); len(excluded) > 0 {
+					properties["excludes"] = strings.Join(excluded, ",")
+				}
+			}
+			b.addAccessEdge(kind, grant.Principal, fileID, reach, properties)
+		}
+	}
+}
+
+// attachToParent links a file to its parent directory, or holds on to it until the directory is seen.
+func (b *graphBuilder) attachToParent(file model.FileInfoRecord, fileID string) {
+	parentPath := filepath.Dir(file.Path)
+	if parentPath == file.Path {
+		return
+	}
+
+	// Mount points can't be removed or renamed while something is mounted on them.
+	child := childEntry{
+		id:     fileID,
+		owner:  graph.UserID(file.UID),
+		isDir:  file.Type == "dir",
+		locked: file.Immutable || file.AppendOnly || file.Path == file.MountPoint,
+	}
+	if parent, ok := b.dirs[parentPath]; ok {
+		b.addChildEdges(parent, child)
+	} else {
+		b.orphans[parentPath] = append(b.orphans[parentPath], child)
+	}
+}
+
+// addLinkChain adds PointsTo edges along the chain of a symlink. Targets that exist are expected to be
+// collected as nodes of their own, missing targets are remembered so that placeholders are made for them.
+func (b *graphBuilder) addLinkChain(linkID string, chain []model.LinkHop) {
+	previous := linkID
+	for _, hop := range chain {
+		if hop.INode == 0 && !hop.Missing {
+			// The target could not be looked at, there is nothing to identify it by.
+			return
+		}
+
+		hopID := graph.FileID(hop.Dev, hop.INode)
+		if hop.Missing {
+			hopID = graph.PathID(hop.Path)
+			b.missingTargets[hop.Path] = append(b.missingTargets[hop.Path], linkID)
+		}
+		// Links further down the chain are often collected themselves, their edges are only added once.
+		if edge := previous + " " + hopID; !b.linkEdges[edge] {
+			b.linkEdges[edge] = true
+			b.writeEdge(idEdge("PointsTo", previous, hopID, nil))
+		}
+		previous = hopID
+	}
+}
 
-		if ownerCanExecute(file.Mode) {
-			edges = append(edges, model.Edge{
-				Kind: "CanExecute",
-				Start: model.Connection{
-					Value:   fmt.Sprintf("uid-%d", file.UID),
-					MatchBy: "id",
-				},
-				End: model.Connection{
-					Value:   fmt.Sprintf("inode-%d", file.INode),
-					MatchBy: "id",
-				},
-			})
-
-			// UID ExecuteAs Edge. Currently only set if the corresponding execute bit is set.
-			if file.SetUID {
-				edges = append(edges, model.Edge{
-					Kind: "ExecuteAs",
-					Start: model.Connection{
-						Value:   fmt.Sprintf("inode-%d", file.INode),
-						MatchBy: "id",
-					},
-					End: model.Connection{
-						Value:   fmt.Sprintf("uid-%d", file.UID),
-						MatchBy: "id",
-					},
-				})
+// addMissingTarget adds a placeholder for a path that symlinks point to but that does not exist, along with
+// the missing directories above it. Whoever can create entries in the first directory above it that exists
+// decides what the links resolve to, which is recorded as CanHijack edges to the links.
+func (b *graphBuilder) addMissingTarget(path string, links []string) {
+	id := graph.PathID(path)
+	b.writeMissingNode(id, path, "file")
+
+	// Missing files can't be removed, they are attached as locked so no delete or replace edges are derived for
+	// them. Attaching stops at a missing directory that is already placed, the walk up goes on to find who can
+	// hijack the links.
+	child := childEntry{id: id, locked: true}
+	attach := true
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		parent, exists, known := b.existingDir(dir)
+		if !known {
+			if attach {
+				b.orphans[dir] = append(b.orphans[dir], child)
 			}
+			return
+		}
+		if exists {
+			if attach {
+				b.addChildEdges(parent, child)
+			}
+			if parent.locked {
+				return
+			}
+			for _, writer := range parent.writers {
+				for _, link := range links {
+					b.addAccessEdge("CanHijack", writer, link, parent.reach, map[string]string{
+						"target": path,
+					})
+				}
+			}
+			return
 		}
 
-		if ownerCanWrite(file.Mode) {
-			edges = append(edges, model.Edge{
-				Kind: "CanWrite",
-				Start: model.Connection{
-					Value:   fmt.Sprintf("uid-%d", file.UID),
-					MatchBy: "id",
-				},
-				End: model.Connection{
-					Value:   fmt.Sprintf("inode-%d", file.INode),
-					MatchBy: "id",
-				},
-			})
-		}
-
-		if ownerCanRead(file.Mode) {
-			edges = append(edges, model.Edge{
-				Kind: "CanRead",
-				Start: model.Connection{
-					Value:   fmt.Sprintf("uid-%d", file.UID),
-					MatchBy: "id",
-				},
-				End: model.Connection{
-					Value:   fmt.Sprintf("inode-%d", file.INode),
-					MatchBy: "id",
-				},
-			})
-		}
-
-		if groupCanExecute(file.Mode) {
-			edges = append(edges, model.Edge{
-				Kind: "CanExecute",
-				Start: model.Connection{
-					Value:   fmt.Sprintf("gid-%d", file.GID),
-					MatchBy: "id",
-				},
-				End: model.Connection{
-					Value:   fmt.Sprintf("inode-%d", file.INode),
-					MatchBy: "id",
-				},
-			})
-
-			// GID ExecuteAs Edge, current only created if the corresponding Execute permission is set.
-			if file.SetGID {
-				edges = append(edges, model.Edge{
-					Kind: "ExecuteAs",
-					Start: model.Connection{
-						Value:   fmt.Sprintf("inode-%d", file.INode),
-						MatchBy: "id",
-					},
-					End: model.Connection{
-						Value:   fmt.Sprintf("gid-%d", file.GID),
-						MatchBy: "id",
-					},
-				})
+		if attach {
+			missing := dirEntry{id: graph.PathID(dir)}
+			if b.missingDirs[dir] {
+				attach = false
+			} else {
+				b.missingDirs[dir] = true
+				b.writeMissingNode(missing.id, dir, "dir")
 			}
+			b.addChildEdges(missing, child)
+			child = childEntry{id: missing.id, isDir: true, locked: true}
+		}
+		if dir == filepath.Dir(dir) {
+			return
 		}
+	}
+}
+
+func (b *graphBuilder) writeMissingNode(id string, path string, fileType string) {
+	b.writeNode(model.Node{
+		ID:          id,
+		Title:       path,
+		Kinds:       []string{fileType},
+		Description: "Nothing exists at this path, it is only known as the target of symlinks",
+		Properties: map[string]string{
+			"name":        path,
+			"type":        fileType,
+			"placeholder": "true",
+			"missing":     "true",
+		},
+	})
+}
+
+// existingDir returns the directory at path when it exists, from what was collected or else by looking at it.
+// known is false when that can't be told, as for records of another system.
+func (b *graphBuilder) existingDir(path string) (dir dirEntry, exists bool, known bool) {
+	if dir, ok := b.dirs[path]; ok {
+		return dir, true, true
+	}
+	if b.opts.Lookup == nil {
+		return dirEntry{}, false, false
+	}
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return dirEntry{}, false, true
+	}
+	if err != nil {
+		return dirEntry{}, false, false
+	}
 
-		if groupCanWrite(file.Mode) {
-			edges = append(edges, model.Edge{
-				Kind: "CanWrite",
-				Start: model.Connection{
-					Value:   fmt.Sprintf("gid-%d", file.GID),
-					MatchBy: "id",
-				},
-				End: model.Connection{
-					Value:   fmt.Sprintf("inode-%d", file.INode),
-					MatchBy: "id",
-				},
-			})
-		}
-
-		if groupCanRead(file.Mode) {
-			edges = append(edges, model.Edge{
-				Kind: "CanRead",
-				Start: model.Connection{
-					Value:   fmt.Sprintf("gid-%d", file.GID),
-					MatchBy: "id",
-				},
-				End: model.Connection{
-					Value:   fmt.Sprintf("inode-%d", file.INode),
-					MatchBy: "id",
-				},
-			})
-		}
-
-		if othersCanExecute(file.Mode) {
-			edges = append(edges, model.Edge{
-				Kind: "CanExecute",
-				Start: model.Connection{
-					Value:   "uid-other",
-					MatchBy: "id",
-				},
-				End: model.Connection{
-					Value:   fmt.Sprintf("inode-%d", file.INode),
-					MatchBy: "id",
-				},
-			})
-		}
-
-		if othersCanWrite(file.Mode) {
-			edges = append(edges, model.Edge{
-				Kind: "CanWrite",
-				Start: model.Connection{
-					Value:   "uid-other",
-					MatchBy: "id",
-				},
-				End: model.Connection{
-					Value:   fmt.Sprintf("inode-%d", file.INode),
-					MatchBy: "id",
-				},
-			})
-		}
-
-		if othersCanRead(file.Mode) {
-			edges = append(edges, model.Edge{
-				Kind: "CanRead",
-				Start: model.Connection{
-					Value:   "uid-other",
-					MatchBy: "id",
-				},
-				End: model.Connection{
-					Value:   fmt.Sprintf("inode-%d", file.INode),
-					MatchBy: "id",
-				},
-			})
+	// The directory was not collected, the walk sends a placeholder for it under the same ID.
+	file := files.ProcessPath(path, info, *b.opts.Lookup)
+	dir = dirEntry{
+		id:     fileNodeID(file),
+		locked: file.Immutable || file.AppendOnly || hasMountOption(file, "ro"),
+		reach:  graph.Everyone().Narrow(file, b.identities),
+	}
+	for _, grant := range b.grants(file) {
+		if grant.Perm&(graph.PermWrite|graph.PermExecute) == graph.PermWrite|graph.PermExecute {
+			dir.writers = append(dir.writers, grant.Principal)
 		}
+	}
+	return dir, true, true
+}
 
+// addTargetPlaceholder adds a node for a file that symlinks lead to but that was not collected, so that the
+// edges to it end at a node. It is only placed in the tree when its directory was collected.
+func (b *graphBuilder) addTargetPlaceholder(file model.FileInfoRecord) {
+	fileID := fileNodeID(file)
+	// A hard link of the target may have been collected under another path.
+	if _, ok := b.links[fileID]; ok {
+		return
 	}
-	return model.GraphEnvelope{
-		Graph: model.Graph{
-			Nodes: nodes,
-			Edges: edges,
+	b.writeNode(model.Node{
+		ID:          fileID,
+		Title:       file.Path,
+		Kinds:       []string{file.Type},
+		Description: "This file was not collected, it is only known through symlinks",
+		Properties: map[string]string{
+			"name":        file.Path,
+			"type":        file.Type,
+			"dev":         fmt.Sprintf("%d", file.Dev),
+			"inode":       fmt.Sprintf("%d", file.INode),
+			"placeholder": "true",
 		},
+	})
+	if parent, ok := b.dirs[filepath.Dir(file.Path)]; ok {
+		b.addChildEdges(parent, childEntry{id: fileID, isDir: file.Type == "dir"})
 	}
 }
 
-func ownerCanExecute(mode os.FileMode) bool {
-	return mode&0o100 != 0
+// capabilityNode returns the ID of the node for a capability, adding it the first time it is used. Capabilities
+// known to lead to root get an EscalatesTo edge to root.
+func (b *graphBuilder) capabilityNode(name string) string {
+	id := graph.CapabilityID(name)
+	if b.capabilities[name] {
+		return id
+	}
+	b.capabilities[name] = true
+
+	b.writeNode(model.Node{
+		ID:    id,
+		Kinds: []string{"Capability"},
+		Title: name,
+		Properties: map[string]string{
+			"name":       name,
+			"escalating": strconv.FormatBool(capabilities.Escalating(name)),
+		},
+	})
+	if capabilities.Escalating(name) {
+		b.writeEdge(idEdge("EscalatesTo", id, graph.UserID(0), nil))
+	}
+	return id
 }
 
-func ownerCanWrite(mode os.FileMode) bool {
-	return mode&0o200 != 0
+// grants returns who holds which access on the file, per user when requested or per owner, group and other.
+func (b *graphBuilder) grants(file model.FileInfoRecord) []graph.Grant {
+	if b.opts.PerUser {
+		return graph.UserGrants(file, b.identities)
+	}
+	return graph.EffectiveGrants(file)
 }
 
-func ownerCanRead(mode os.FileMode) bool {
-	return mode&0o400 != 0
+// addAccessEdge adds an edge granting access to target, marking or dropping it when none of the users the
+// principal stands for can reach the target through its parent directories.
+func (b *graphBuilder) addAccessEdge(kind string, principal string, target string, reach graph.Reach, properties map[string]string) {
+	var excluded []string
+	if properties["excludes"] != "" {
+		excluded = strings.Split(properties["excludes"], ",")
+	}
+	if !b.reachable(principal, reach, excluded) {
+		if b.opts.PruneUnreachable {
+			return
+		}
+		if properties == nil {
+			properties = map[string]string{}
+		}
+		properties["reachable"] = "false"
+	}
+	b.writeEdge(idEdge(kind, principal, target, properties))
+}
+
+// reachable tells whether a member that gets the access through the principal is in reach. Members of a
+// group or Everyone that search every directory regardless, such as root, hold the access on their own, as do
+// members the edge excludes, so neither makes the edge reachable. Principals without any known member, such as
+// owners missing from the passwd file, are never taken to be stopped.
+func (b *graphBuilder) reachable(principal string, reach graph.Reach, excluded []string) bool {
+	members, ok := b.members[principal]
+	if !ok {
+		return true
+	}
+	isUser := strings.HasPrefix(principal, "uid-")
+	for _, member := range members {
+		if !isUser && (member.SearchAll || excludes(excluded, member)) {
+			continue
+		}
+		if reach.Has(member.UID) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludes tells whether the member is the user or in one of the groups listed in excluded.
+func excludes(excluded []string, member graph.Identity) bool {
+	for _, principal := range excluded {
+		if principal == graph.UserID(member.UID) {
+			return true
+		}
+		for gid := range member.GIDs {
+			if principal == graph.GroupID(gid) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addChildEdges links a directory to one of its children and derives the edges the directory's writers hold
+// over the child. Anyone who can write and search a directory can unlink its entries, and can rename their
+// own file over any child that is not a directory, regardless of who owns that child, unless the directory is sticky.
+func (b *graphBuilder) addChildEdges(parent dirEntry, child childEntry) {
+	b.writeEdge(idEdge("Contains", parent.id, child.id, nil))
+	if parent.locked || child.locked {
+		return
+	}
+	for _, writer := range parent.writers {
+		if parent.sticky && writer != child.owner && writer != parent.owner {
+			continue
+		}
+		b.addAccessEdge("CanDeleteChild", writer, child.id, parent.reach, nil)
+		if !child.isDir {
+			b.addAccessEdge("CanReplace", writer, child.id, parent.reach, nil)
+		}
+	}
+}
+
+// finish writes the hard-linked files still waiting for links, then creates placeholder nodes for missing
+// symlink targets and for the directories that children were seen in but that were not collected themselves, such as the ancestors of the starting path, so every node has a path up to /.
+func (b *graphBuilder) finish() {
+	// Hard-linked files with links outside of what was collected are written with the paths that were seen.
+	ids := make([]string, 0, len(b.links))
+	for id, linked := range b.links {
+		if linked != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		b.writeLinkedNode(id, b.links[id])
+	}
+
+	paths := make([]string, 0, len(b.missingTargets))
+	for path := range b.missingTargets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		b.addMissingTarget(path, b.missingTargets[path])
+	}
+
+	for len(b.orphans) > 0 {
+		paths := make([]string, 0, len(b.orphans))
+		for path := range b.orphans {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			if _, ok := b.orphans[path]; ok {
+				b.addPlaceholder(path)
+			}
+		}
+	}
+}
+
+func (b *graphBuilder) addPlaceholder(path string) {
+	dir := dirEntry{id: graph.PathID(path), reach: graph.Everyone()}
+	b.writeNode(model.Node{
+		ID:          dir.id,
+		Title:       path,
+		Kinds:       []string{"dir"},
+		Description: "This directory was not collected, it is only known as the parent of collected files",
+		Properties: map[string]string{
+			"name":        path,
+			"type":        "dir",
+			"placeholder": "true",
+		},
+	})
+
+	b.dirs[path] = dir
+	for _, orphan := range b.orphans[path] {
+		b.addChildEdges(dir, orphan)
+	}
+	delete(b.orphans, path)
+
+	parentPath := filepath.Dir(path)
+	if parentPath == path {
+		return
+	}
+	child := childEntry{id: dir.id, isDir: true}
+	if parent, ok := b.dirs[parentPath]; ok {
+		b.addChildEdges(parent, child)
+	} else {
+		b.orphans[parentPath] = append(b.orphans[parentPath], child)
+	}
+}
+
+// fileNodeID identifies a file by device and inode. Records that could not be stat'ed have neither and
+// are identified by their path instead.
+func fileNodeID(file model.FileInfoRecord) string {
+	if file.INode == 0 {
+		return graph.PathID(file.Path)
+	}
+	return graph.FileID(file.Dev, file.INode)
+}
+
+// selinuxContext splits an SELinux label of the form user:role:type:level. The level is optional and
+// may itself contain colons, as in s0:c0.c1023.
+func selinuxContext(label string) (string, string, string, string) {
+	parts := strings.SplitN(label, ":", 4)
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+	return parts[0], parts[1], parts[2], parts[3]
+}
+
+func idEdge(kind string, start string, end string, properties map[string]string) model.Edge {
+	return model.Edge{
+		Kind: kind,
+		Start: model.Connection{
+			Value:   start,
+			MatchBy: "id",
+		},
+		End: model.Connection{
+			Value:   end,
+			MatchBy: "id",
+		},
+		Properties: properties,
+	}
+}
+
+func ownerCanExecute(mode os.FileMode) bool {
+	return mode&0o100 != 0
 }
 
 func groupCanExecute(mode os.FileMode) bool {
 	return mode&0o010 != 0
 }
 
-func groupCanWrite(mode os.FileMode) bool {
-	return mode&0o020 != 0
+// edgeKindPerms holds the permission bits each access edge kind needs.
+var edgeKindPerms = map[string]uint8{
+	"CanExecute":  graph.PermExecute,
+	"CanWrite":    graph.PermWrite,
+	"CanAppend":   graph.PermWrite,
+	"CanRead":     graph.PermRead,
+	"CanTraverse": graph.PermExecute,
+	"CanCreateIn": graph.PermWrite | graph.PermExecute,
+	"CanList":     graph.PermRead,
+}
+
+func permissionEdgeKinds(perm uint8) []string {
+	var kinds []string
+	if perm&graph.PermExecute != 0 {
+		kinds = append(kinds, "CanExecute")
+	}
+	if perm&graph.PermWrite != 0 {
+		kinds = append(kinds, "CanWrite")
+	}
+	if perm&graph.PermRead != 0 {
+		kinds = append(kinds, "CanRead")
+	}
+	return kinds
+}
+
+// restrictKinds applies the inode flags and mount options to the access edge kinds of a file. Nothing can be
+// written to an immutable file, created in an immutable directory or changed on a read-only mount, nothing
+// on a noexec mount can be executed, and append-only files can only be appended to.
+func restrictKinds(file model.FileInfoRecord, kinds []string) []string {
+	readOnly := file.Immutable || hasMountOption(file, "ro")
+	noExec := hasMountOption(file, "noexec")
+
+	var restricted []string
+	for _, kind := range kinds {
+		switch {
+		case readOnly && (kind == "CanWrite" || kind == "CanCreateIn"):
+			continue
+		case noExec && kind == "CanExecute":
+			continue
+		case file.AppendOnly && kind == "CanWrite":
+			kind = "CanAppend"
+		}
+		restricted = append(restricted, kind)
+	}
+	return restricted
 }
 
-func groupCanRead(mode os.FileMode) bool {
-	return mode&0o040 != 0
+func hasMountOption(file model.FileInfoRecord, option string) bool {
+	for _, o := range file.MountOptions {
+		if o == option {
+			return true
+		}
+	}
+	return false
 }
 
-func othersCanExecute(mode os.FileMode) bool {
-	return mode&0o001 != 0
+// directoryEdgeKinds maps permission bits to what they mean on a directory: read lists the entries,
+// execute searches it, and write together with execute creates and removes entries.
+func directoryEdgeKinds(perm uint8) []string {
+	var kinds []string
+	if perm&graph.PermExecute != 0 {
+		kinds = append(kinds, "CanTraverse")
+		if perm&graph.PermWrite != 0 {
+			kinds = append(kinds, "CanCreateIn")
+		}
+	}
+	if perm&graph.PermRead != 0 {
+		kinds = append(kinds, "CanList")
+	}
+	return kinds
 }
 
-func othersCanWrite(mode os.FileMode) bool {
-	return mode&0o002 != 0
+func permString(perm uint8) string {
+	b := []byte("---")
+	if perm&graph.PermRead != 0 {
+		b[0] = 'r'
+	}
+	if perm&graph.PermWrite != 0 {
+		b[1] = 'w'
+	}
+	if perm&graph.PermExecute != 0 {
+		b[2] = 'x'
+	}
+	return string(b)
 }
 
-func othersCanRead(mode os.FileMode) bool {
-	return mode&0o004 != 0
+// dacReadSearch tells whether capabilities let a user search and read every directory.
+func dacReadSearch(caps []string) bool {
+	return capabilities.Contains(caps, capabilities.DACOverride) || capabilities.Contains(caps, capabilities.DACReadSearch)
 }
@@ -0,0 +1,239 @@
+package writer
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/mykeelium/lshound/identity"
+	model "github.com/mykeelium/lshound/model"
+)
+
+func testIndex() *identity.Index {
+	return identity.NewOfflineIndex(
+		[]model.User{
+			{Username: "root", UID: 0, GID: 0},
+			{Username: "alice", UID: 1000, GID: 100},
+			{Username: "bob", UID: 1001, GID: 100},
+		},
+		[]model.Group{
+			{Name: "root", GID: 0},
+			{Name: "staff", GID: 100},
+		},
+	)
+}
+
+func dir(path string, inode uint64, mode os.FileMode) model.FileInfoRecord {
+	return model.FileInfoRecord{
+		Path:   path,
+		Type:   "dir",
+		Mode:   os.ModeDir | mode,
+		Dev:    1,
+		INode:  inode,
+		NLink:  2,
+		Sticky: mode&os.ModeSticky != 0,
+	}
+}
+
+func file(path string, inode uint64, mode os.FileMode) model.FileInfoRecord {
+	return model.FileInfoRecord{
+		Path:   path,
+		Type:   "file",
+		Mode:   mode,
+		Dev:    1,
+		INode:  inode,
+		NLink:  1,
+		SetUID: mode&os.ModeSetuid != 0,
+	}
+}
+
+// graphEdges feeds the records through CreateGraph and returns its edges as kind, start and end, followed by
+// reachable=false for edges that are marked unreachable.
+func graphEdges(records []model.FileInfoRecord, opts GraphOptions) []string {
+	fileChannel := make(chan model.FileInfoRecord, len(records))
+	for _, record := range records {
+		fileChannel <- record
+	}
+	close(fileChannel)
+
+	var edges []string
+	for _, edge := range CreateGraph(testIndex(), fileChannel, opts).Graph.Edges {
+		s := edge.Kind + " " + edge.Start.Value + " " + edge.End.Value
+		if edge.Properties["reachable"] == "false" {
+			s += " reachable=false"
+		}
+		edges = append(edges, s)
+	}
+	return edges
+}
+
+func TestCreateGraphEdges(t *testing.T) {
+	immutable := file("/srv/immutable", 3, 0o666)
+	immutable.Immutable = true
+	appendOnly := file("/srv/log", 4, 0o666)
+	appendOnly.AppendOnly = true
+
+	hidden := file("/hidden/sudo", 5, os.ModeSetuid|0o755)
+	hidden.NLink = 2
+	shared := hidden
+	shared.Path = "/shared/sudo"
+
+	tests := []struct {
+		name    string
+		records []model.FileInfoRecord
+		opts    GraphOptions
+		want    []string
+		absent  []string
+	}{
+		{
+			name: "world readable file below a 0700 directory",
+			records: []model.FileInfoRecord{
+				dir("/", 1, 0o755),
+				dir("/a", 2, 0o700),
+				dir("/a/b", 3, 0o755),
+				file("/a/b/f", 4, 0o644),
+			},
+			want: []string{
+				"CanRead uid-0 inode-1-4",
+				"CanRead gid-0 inode-1-4 reachable=false",
+				"CanRead gid-everyone inode-1-4 reachable=false",
+				"CanTraverse gid-everyone inode-1-3 reachable=false",
+				"CanTraverse gid-everyone inode-1-1",
+			},
+			absent: []string{
+				"CanRead gid-everyone inode-1-4",
+			},
+		},
+		{
+			name: "unreachable edges are pruned",
+			records: []model.FileInfoRecord{
+				dir("/", 1, 0o755),
+				dir("/a", 2, 0o700),
+				file("/a/f", 3, 0o644),
+			},
+			opts: GraphOptions{PruneUnreachable: true},
+			want: []string{
+				"CanRead uid-0 inode-1-3",
+			},
+			absent: []string{
+				"CanRead gid-everyone inode-1-3",
+				"CanRead gid-everyone inode-1-3 reachable=false",
+			},
+		},
+		{
+			name: "sticky directory",
+			records: []model.FileInfoRecord{
+				dir("/", 1, 0o755),
+				dir("/tmp", 2, os.ModeSticky|0o777),
+				{Path: "/tmp/f", Type: "file", Mode: 0o644, Dev: 1, INode: 3, NLink: 1, UID: 1000, GID: 100},
+			},
+			want: []string{
+				"Contains inode-1-2 inode-1-3",
+				"CanDeleteChild uid-0 inode-1-3",
+				"CanReplace uid-0 inode-1-3",
+				"CanCreateIn gid-everyone inode-1-2",
+			},
+			absent: []string{
+				"CanDeleteChild gid-everyone inode-1-3",
+				"CanReplace gid-everyone inode-1-3",
+				"CanDeleteChild gid-0 inode-1-3",
+			},
+		},
+		{
+			name: "directory without the sticky bit",
+			records: []model.FileInfoRecord{
+				dir("/", 1, 0o755),
+				dir("/srv", 2, 0o777),
+				file("/srv/f", 3, 0o644),
+			},
+			want: []string{
+				"CanDeleteChild gid-everyone inode-1-3",
+				"CanReplace gid-everyone inode-1-3",
+			},
+		},
+		{
+			name: "immutable and append-only files",
+			records: []model.FileInfoRecord{
+				dir("/", 1, 0o755),
+				dir("/srv", 2, 0o777),
+				immutable,
+				appendOnly,
+			},
+			want: []string{
+				"CanRead gid-everyone inode-1-3",
+				"CanRead gid-everyone inode-1-4",
+				"CanAppend gid-everyone inode-1-4",
+				"Contains inode-1-2 inode-1-3",
+			},
+			absent: []string{
+				"CanWrite gid-everyone inode-1-3",
+				"CanWrite gid-everyone inode-1-4",
+				"CanDeleteChild gid-everyone inode-1-3",
+				"CanReplace gid-everyone inode-1-3",
+				"CanDeleteChild gid-everyone inode-1-4",
+			},
+		},
+		{
+			name: "file with two hard links",
+			records: []model.FileInfoRecord{
+				dir("/", 1, 0o755),
+				dir("/hidden", 2, 0o700),
+				hidden,
+				dir("/shared", 3, 0o755),
+				shared,
+			},
+			want: []string{
+				"Contains inode-1-2 inode-1-5",
+				"Contains inode-1-3 inode-1-5",
+				"ExecuteAs inode-1-5 uid-0",
+				"CanExecute gid-everyone inode-1-5",
+				"CanRead gid-everyone inode-1-5",
+			},
+			absent: []string{
+				"CanExecute gid-everyone inode-1-5 reachable=false",
+				"CanRead gid-everyone inode-1-5 reachable=false",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edges := graphEdges(tt.records, tt.opts)
+			for _, want := range tt.want {
+				if !slices.Contains(edges, want) {
+					t.Errorf("missing edge %q in %q", want, edges)
+				}
+			}
+			for _, absent := range tt.absent {
+				if slices.Contains(edges, absent) {
+					t.Errorf("unexpected edge %q", absent)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateGraphHardLinkNode(t *testing.T) {
+	first := file("/a/f", 2, 0o644)
+	first.NLink = 2
+	second := first
+	second.Path = "/b/f"
+
+	fileChannel := make(chan model.FileInfoRecord, 2)
+	fileChannel <- first
+	fileChannel <- second
+	close(fileChannel)
+
+	var nodes []model.Node
+	for _, node := range CreateGraph(testIndex(), fileChannel, GraphOptions{}).Graph.Nodes {
+		if node.ID == "inode-1-2" {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) != 1 {
+		t.Fatalf("got %d nodes for the hard-linked file, want 1", len(nodes))
+	}
+	if got := nodes[0].Properties["paths"]; got != "/a/f,/b/f" {
+		t.Errorf("paths = %q, want /a/f,/b/f", got)
+	}
+}